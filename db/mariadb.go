@@ -2,7 +2,6 @@ package db
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/jinzhu/gorm"
 )
@@ -21,77 +20,30 @@ var sample = `
 
 // Mariadb is 数据库配置.
 type Mariadb struct {
-	Host         string `json:"host" toml:"host" description:"数据库地址"`
-	Port         int    `json:"port" toml:"port" description:"数据库端口"`
-	Username     string `json:"username" toml:"username" description:"数据库访问用户名"`
-	Password     string `json:"password" toml:"password" description:"数据库访问密码"`
-	Database     string `json:"database" toml:"database" description:"数据库名称"`
-	MaxOpenConns int    `json:"maxOpenConns" toml:"maxOpenConns" description:"数据库最大连接数"`
-	MaxIdleConns int    `json:"maxIdleConns" toml:"maxIdleConns" description:"数据库最大空闲连接数"`
-	IdleTime     int64  `json:"idleTime" toml:"idleTime" description:"数据库最大空闲时间"`
+	Host     string `json:"host" toml:"host" description:"数据库地址"`
+	Port     int    `json:"port" toml:"port" description:"数据库端口"`
+	Username string `json:"username" toml:"username" description:"数据库访问用户名"`
+	Password string `json:"password" toml:"password" description:"数据库访问密码"`
+	Database string `json:"database" toml:"database" description:"数据库名称"`
+	SQLBase
+}
+
+// DSN is 返回 MySQL 驱动的连接字符串.
+func (p *Mariadb) DSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=True&loc=Local", p.Username, p.Password, p.Host, p.Port, p.Database)
+}
 
-	db *gorm.DB
+// Dialect is 返回 MySQL 方言.
+func (p *Mariadb) Dialect() Dialect {
+	return Dialect{Name: "mysql", Driver: "mysql"}
 }
 
 // NewConn is 创建数据库连接.
 func (p *Mariadb) NewConn() (*gorm.DB, error) {
-	db, err := gorm.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=True&loc=Local", p.Username, p.Password, p.Host, p.Port, p.Database))
-	if err != nil {
-		return nil, err
-	}
-	db.DB().SetMaxIdleConns(p.MaxIdleConns)
-	db.DB().SetMaxOpenConns(p.MaxOpenConns)
-	db.DB().SetConnMaxLifetime(time.Duration(p.IdleTime) * time.Second)
-	return db, nil
+	return openGorm(p, p.Pool())
 }
 
 // Init is 初始化数据库连接.
 func (p *Mariadb) Init() error {
-	db, err := gorm.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=True&loc=Local", p.Username, p.Password, p.Host, p.Port, p.Database))
-	if err != nil {
-		return err
-	}
-	db.DB().SetMaxIdleConns(p.MaxIdleConns)
-	db.DB().SetMaxOpenConns(p.MaxOpenConns)
-	p.db = db
-	return nil
-}
-
-// GetSQL is 通过 sql 语句查询数据库.
-func (p *Mariadb) GetSQL(sql string) ([]map[string]interface{}, error) {
-	rows, err := p.db.Raw(sql).Rows()
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	columns, err := rows.Columns()
-	if err != nil {
-		return nil, err
-	}
-	size := len(columns)
-	pts := make([]interface{}, size)
-	c := make([]interface{}, size)
-	container := make([]map[string]interface{}, 0)
-	for i := range pts {
-		pts[i] = &c[i]
-	}
-	for rows.Next() {
-		err = rows.Scan(pts...)
-		if err != nil {
-			return nil, err
-		}
-		var r = make(map[string]interface{}, size)
-		for i, column := range columns {
-			val := pts[i].(*interface{})
-			b, ok := (*val).([]byte)
-			if ok {
-				v := string(b)
-				r[column] = v
-			} else {
-				r[column] = *val
-			}
-		}
-		container = append(container, r)
-	}
-	return container, nil
+	return p.Open(p)
 }
@@ -0,0 +1,308 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Dialect is SQL 数据库方言.
+type Dialect struct {
+	// Name 为方言名称, 如 mysql、oracle、sqlite.
+	Name string
+	// Driver 为 database/sql 注册的驱动名称, 使用前需导入对应驱动.
+	Driver string
+	// Bind 为驱动的占位符语法.
+	Bind BindType
+}
+
+// SQLSource is SQL 数据源, 新的 SQL 数据库只需实现该接口即可使用 SQLClient.
+type SQLSource interface {
+	// DSN 返回驱动的连接字符串.
+	DSN() string
+	// Dialect 返回数据库方言.
+	Dialect() Dialect
+}
+
+// SQLPool is 连接池配置.
+type SQLPool struct {
+	MaxOpenConns int
+	MaxIdleConns int
+	// IdleTime 为连接最大存活时间, 单位秒, 0 为不限制.
+	IdleTime int64
+}
+
+var errSQLNotInit = errors.New("数据库未初始化,请先调用Init")
+
+// openDB 按数据源和连接池配置打开 database/sql 连接池.
+func openDB(src SQLSource, pool SQLPool) (*sql.DB, error) {
+	db, err := sql.Open(src.Dialect().Driver, src.DSN())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(pool.IdleTime) * time.Second)
+	return db, nil
+}
+
+// SQLBase is SQL 数据库配置的公共部分, 包括连接池配置及 Init 创建的客户端,
+// 嵌入到 Mariadb、Oracle、SQLite 等配置中. 新的 SQL 数据库只需嵌入 SQLBase、
+// 实现 SQLSource, 并在 Init 中调用 Open.
+type SQLBase struct {
+	MaxOpenConns int   `json:"maxOpenConns" toml:"maxOpenConns" description:"数据库最大连接数"`
+	MaxIdleConns int   `json:"maxIdleConns" toml:"maxIdleConns" description:"数据库最大空闲连接数"`
+	IdleTime     int64 `json:"idleTime" toml:"idleTime" description:"数据库最大空闲时间"`
+
+	db *SQLClient
+}
+
+// Pool is 返回连接池配置.
+func (b *SQLBase) Pool() SQLPool {
+	return SQLPool{MaxOpenConns: b.MaxOpenConns, MaxIdleConns: b.MaxIdleConns, IdleTime: b.IdleTime}
+}
+
+// Open is 按 src 及连接池配置创建数据库客户端, 通过 Client 获取.
+func (b *SQLBase) Open(src SQLSource) error {
+	c, err := OpenSQL(src, b.Pool())
+	if err != nil {
+		return err
+	}
+	b.db = c
+	return nil
+}
+
+// Client is 返回 Init 创建的数据库客户端.
+func (b *SQLBase) Client() *SQLClient {
+	return b.db
+}
+
+// GetSQL is 通过 sql 语句查询数据库, 一次加载全部结果, 结果集较大时使用 GetSQLRows.
+func (b *SQLBase) GetSQL(sql string) ([]map[string]interface{}, error) {
+	return b.db.GetSQL(sql)
+}
+
+// GetSQLContext is 使用参数查询数据库, ctx 结束时取消查询, 参数规则见 SQLClient.QueryContext.
+func (b *SQLBase) GetSQLContext(ctx context.Context, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	return b.db.QueryContext(ctx, query, args...)
+}
+
+// GetSQLRows is 使用参数查询数据库并返回逐行读取结果的游标, 用于大结果集, 参数规则见 SQLClient.QueryContext.
+func (b *SQLBase) GetSQLRows(ctx context.Context, query string, args ...interface{}) (*RowIterator, error) {
+	return b.db.Rows(ctx, query, args...)
+}
+
+// ExecContext is 使用参数执行插入、更新等语句, 参数规则见 SQLClient.QueryContext.
+func (b *SQLBase) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return b.db.ExecContext(ctx, query, args...)
+}
+
+// SQLClient is 基于 database/sql 的数据库客户端, 提供查询、执行、事务和行扫描,
+// Mariadb、Oracle、SQLite 的 Init 均创建该客户端.
+type SQLClient struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// OpenSQL is 按数据源和连接池配置创建数据库客户端, 并检查数据库是否可以连接.
+func OpenSQL(src SQLSource, pool SQLPool) (*SQLClient, error) {
+	db, err := openDB(src, pool)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return NewSQLClient(db, src.Dialect()), nil
+}
+
+// NewSQLClient is 使用已打开的连接池创建数据库客户端.
+func NewSQLClient(db *sql.DB, dialect Dialect) *SQLClient {
+	return &SQLClient{db: db, dialect: dialect}
+}
+
+// DB is 返回底层连接池.
+func (c *SQLClient) DB() *sql.DB {
+	if c == nil {
+		return nil
+	}
+	return c.db
+}
+
+// Dialect is 返回数据库方言.
+func (c *SQLClient) Dialect() Dialect {
+	return c.dialect
+}
+
+// Close is 关闭连接池.
+func (c *SQLClient) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.db.Close()
+}
+
+// GetSQL is 通过 sql 语句查询数据库, 一次加载全部结果, 结果集较大时使用 Rows.
+func (c *SQLClient) GetSQL(sql string) ([]map[string]interface{}, error) {
+	return c.QueryContext(context.Background(), sql)
+}
+
+// Query is 执行查询并返回所有行, 参数规则同 QueryContext.
+func (c *SQLClient) Query(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext is 执行查询并返回所有行, ctx 结束时取消查询, 结果集较大时使用 Rows 逐行读取.
+// args 为位置参数时 query 使用 ? 占位符, 按方言转换, 如 Oracle 中转换为 :1、:2;
+// args 全部为 sql.Named 时 query 使用 :name 命名参数, 两种参数不能混用.
+func (c *SQLClient) QueryContext(ctx context.Context, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	if c == nil {
+		return nil, errSQLNotInit
+	}
+	query, args, err := c.bind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return ScanRows(rows)
+}
+
+// QueryNamed is 使用 :name 命名参数执行查询, arg 为 map 或结构体, 见 Dialect.BindNamed.
+func (c *SQLClient) QueryNamed(ctx context.Context, query string, arg interface{}) ([]map[string]interface{}, error) {
+	if c == nil {
+		return nil, errSQLNotInit
+	}
+	query, args, err := c.dialect.BindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return ScanRows(rows)
+}
+
+// Exec is 执行插入、更新等不返回行的语句, 参数规则同 QueryContext.
+func (c *SQLClient) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext is 执行插入、更新等不返回行的语句, ctx 结束时取消执行, 参数规则同 QueryContext.
+func (c *SQLClient) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if c == nil {
+		return nil, errSQLNotInit
+	}
+	query, args, err := c.bind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return c.db.ExecContext(ctx, query, args...)
+}
+
+// ExecNamed is 使用 :name 命名参数执行语句, arg 为 map 或结构体, 见 Dialect.BindNamed.
+func (c *SQLClient) ExecNamed(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	if c == nil {
+		return nil, errSQLNotInit
+	}
+	query, args, err := c.dialect.BindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return c.db.ExecContext(ctx, query, args...)
+}
+
+// bind 按方言转换 query 的占位符.
+func (c *SQLClient) bind(query string, args []interface{}) (string, []interface{}, error) {
+	if len(args) == 0 {
+		return query, args, nil
+	}
+	var named map[string]interface{}
+	for _, a := range args {
+		if na, ok := a.(sql.NamedArg); ok {
+			if named == nil {
+				named = make(map[string]interface{}, len(args))
+			}
+			if _, ok := named[na.Name]; ok {
+				return "", nil, fmt.Errorf("SQL命名参数%s重复", na.Name)
+			}
+			named[na.Name] = na.Value
+		}
+	}
+	if named == nil {
+		return c.dialect.Rebind(query), args, nil
+	}
+	if len(named) != len(args) {
+		return "", nil, errors.New("SQL位置参数和命名参数不能混用")
+	}
+	return c.dialect.BindNamed(query, named)
+}
+
+// Transaction is 在事务中执行 fn, fn 返回 nil 时提交, 返回错误或 panic 时回滚.
+func (c *SQLClient) Transaction(fn func(tx *sql.Tx) error) error {
+	return c.TransactionContext(context.Background(), nil, fn)
+}
+
+// TransactionContext is 按 opts 开启事务并执行 fn, ctx 结束时事务回滚.
+func (c *SQLClient) TransactionContext(ctx context.Context, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	if c == nil {
+		return errSQLNotInit
+	}
+	tx, err := c.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			return fmt.Errorf("%v,回滚失败.%v", err, rerr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// ScanRows is 读取 rows 的所有行, 每行为列名到值的映射, []byte 类型的值转换为 string.
+// 不关闭 rows.
+func ScanRows(rows *sql.Rows) ([]map[string]interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	size := len(columns)
+	pts := make([]interface{}, size)
+	c := make([]interface{}, size)
+	container := make([]map[string]interface{}, 0)
+	for i := range pts {
+		pts[i] = &c[i]
+	}
+	for rows.Next() {
+		err = rows.Scan(pts...)
+		if err != nil {
+			return nil, err
+		}
+		var r = make(map[string]interface{}, size)
+		for i, column := range columns {
+			if b, ok := c[i].([]byte); ok {
+				r[column] = string(b)
+			} else {
+				r[column] = c[i]
+			}
+		}
+		container = append(container, r)
+	}
+	return container, rows.Err()
+}
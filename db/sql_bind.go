@@ -0,0 +1,228 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// BindType is 驱动的占位符语法.
+type BindType int
+
+const (
+	// BIND_QUESTION is ? 占位符, 如 MySQL、SQLite.
+	BIND_QUESTION BindType = iota
+	// BIND_COLON is :1、:2 占位符, 如 Oracle.
+	BIND_COLON
+	// BIND_DOLLAR is $1、$2 占位符, 如 PostgreSQL.
+	BIND_DOLLAR
+)
+
+// placeholder 返回第 n 个参数的占位符, n 从 1 开始.
+func (b BindType) placeholder(n int) string {
+	switch b {
+	case BIND_COLON:
+		return ":" + strconv.Itoa(n)
+	case BIND_DOLLAR:
+		return "$" + strconv.Itoa(n)
+	default:
+		return "?"
+	}
+}
+
+// Rebind is 将使用 ? 占位符的 query 转换为方言的占位符语法,
+// 字符串、带引号的标识符和注释中的 ? 不转换.
+func (d Dialect) Rebind(query string) string {
+	if d.Bind == BIND_QUESTION {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	scanSQL(query, d.Name == "mysql", func(s string, code bool) {
+		if !code {
+			b.WriteString(s)
+			return
+		}
+		for {
+			i := strings.IndexByte(s, '?')
+			if i < 0 {
+				b.WriteString(s)
+				return
+			}
+			n++
+			b.WriteString(s[:i])
+			b.WriteString(d.Bind.placeholder(n))
+			s = s[i+1:]
+		}
+	})
+	return b.String()
+}
+
+// Limit is 为 query 追加限制返回行数为 n 的子句, Oracle 为 FETCH FIRST n ROWS ONLY (12c 及以上), 其他为 LIMIT n.
+func (d Dialect) Limit(query string, n int) string {
+	if d.Name == "oracle" {
+		return query + " FETCH FIRST " + strconv.Itoa(n) + " ROWS ONLY"
+	}
+	return query + " LIMIT " + strconv.Itoa(n)
+}
+
+// BindNamed is 将使用 :name 命名参数的 query 转换为方言的位置占位符, 并按出现顺序返回参数.
+// arg 为 map[string]interface{} 或结构体, 结构体字段名取 db 标签, 没有标签时为字段名, 标签为 - 时忽略.
+// 字符串、带引号的标识符和注释中的 :name 以及 :: 不转换.
+func (d Dialect) BindNamed(query string, arg interface{}) (string, []interface{}, error) {
+	values, err := namedValues(arg)
+	if err != nil {
+		return "", nil, err
+	}
+	var b strings.Builder
+	var args []interface{}
+	scanSQL(query, d.Name == "mysql", func(s string, code bool) {
+		if !code || err != nil {
+			b.WriteString(s)
+			return
+		}
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+			if c != ':' {
+				b.WriteByte(c)
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == ':' {
+				b.WriteString("::")
+				i++
+				continue
+			}
+			j := i + 1
+			for j < len(s) && isNameChar(s[j], j == i+1) {
+				j++
+			}
+			if j == i+1 {
+				b.WriteByte(c)
+				continue
+			}
+			name := s[i+1 : j]
+			v, ok := values[name]
+			if !ok {
+				err = fmt.Errorf("缺少SQL参数%s", name)
+				return
+			}
+			args = append(args, v)
+			b.WriteString(d.Bind.placeholder(len(args)))
+			i = j - 1
+		}
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return b.String(), args, nil
+}
+
+// isNameChar 判断 c 是否可以作为参数名的字符, 首字符不能是数字.
+func isNameChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+// namedValues 将 map 或结构体转换为参数表.
+func namedValues(arg interface{}) (map[string]interface{}, error) {
+	if m, ok := arg.(map[string]interface{}); ok {
+		return m, nil
+	}
+	v := reflect.ValueOf(arg)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, fmt.Errorf("SQL命名参数不能为nil")
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		m := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			m[k.String()] = v.MapIndex(k).Interface()
+		}
+		return m, nil
+	case reflect.Struct:
+		t := v.Type()
+		m := make(map[string]interface{}, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := f.Tag.Get("db")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			m[name] = v.Field(i).Interface()
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("SQL命名参数必须是map或结构体,实际为%T", arg)
+}
+
+// scanSQL 将 query 拆分为代码段和字符串、标识符、注释段, 按顺序调用 fn, code 为 true 时为代码段.
+// mysql 为 true 时按 MySQL 语法, 字符串中的 \ 为转义符, # 开始单行注释.
+func scanSQL(query string, mysql bool, fn func(s string, code bool)) {
+	start := 0
+	for i := 0; i < len(query); i++ {
+		var end int
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			end = quoteEnd(query, i+1, c, mysql && c != '`')
+		case c == '-' && strings.HasPrefix(query[i:], "--"), c == '#' && mysql:
+			end = strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i + 1
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end = strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i + 4
+			}
+		default:
+			continue
+		}
+		if i > start {
+			fn(query[start:i], true)
+		}
+		fn(query[i:end], false)
+		start = end
+		i = end - 1
+	}
+	if start < len(query) {
+		fn(query[start:], true)
+	}
+}
+
+// quoteEnd 返回从 i 开始、以 q 结束的引号段的结束位置, 连续两个 q 视为转义.
+func quoteEnd(query string, i int, q byte, backslash bool) int {
+	for i < len(query) {
+		if query[i] == q {
+			if i+1 < len(query) && query[i+1] == q {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		if backslash && query[i] == '\\' {
+			i += 2
+			continue
+		}
+		i++
+	}
+	return len(query)
+}
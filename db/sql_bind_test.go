@@ -0,0 +1,114 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/zhgqiang/commongo/db"
+)
+
+func TestDialectRebind(t *testing.T) {
+	d := (&db.Oracle{}).Dialect()
+	got := d.Rebind("SELECT '?', \"a?\" FROM t -- ?\nWHERE id = ? /* ? */ AND name = ?")
+	want := "SELECT '?', \"a?\" FROM t -- ?\nWHERE id = :1 /* ? */ AND name = :2"
+	if got != want {
+		t.Fatalf("Rebind = %q, 期望 %q", got, want)
+	}
+	if q := (&db.Mariadb{}).Dialect().Rebind("id = ?"); q != "id = ?" {
+		t.Fatalf("MySQL 不应转换占位符, 得到 %q", q)
+	}
+}
+
+func TestDialectLimit(t *testing.T) {
+	if q := (&db.Oracle{}).Dialect().Limit("SELECT id FROM t", 10); q != "SELECT id FROM t FETCH FIRST 10 ROWS ONLY" {
+		t.Fatalf("Oracle Limit = %q", q)
+	}
+	if q := (&db.Mariadb{}).Dialect().Limit("SELECT id FROM t", 10); q != "SELECT id FROM t LIMIT 10" {
+		t.Fatalf("MySQL Limit = %q", q)
+	}
+}
+
+func TestDialectBindNamed(t *testing.T) {
+	d := (&db.Oracle{}).Dialect()
+	type device struct {
+		ID     int    `db:"id"`
+		Name   string `db:"name"`
+		Ignore string `db:"-"`
+	}
+	q, args, err := d.BindNamed("SELECT id::text, ':name' FROM t WHERE id = :id AND name = :name OR id = :id",
+		device{ID: 1, Name: "d1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SELECT id::text, ':name' FROM t WHERE id = :1 AND name = :2 OR id = :3"; q != want {
+		t.Fatalf("BindNamed = %q, 期望 %q", q, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{1, "d1", 1}) {
+		t.Fatalf("参数 %v", args)
+	}
+	if _, _, err := d.BindNamed("id = :id AND x = :Ignore", device{}); err == nil {
+		t.Fatal("缺少参数时应返回错误")
+	}
+	if _, _, err := d.BindNamed("id = :id", 1); err == nil {
+		t.Fatal("参数不是 map 或结构体时应返回错误")
+	}
+
+	// MySQL 中 # 开始单行注释, 其他数据库中 # 不是注释
+	q, args, err = (&db.Mariadb{}).Dialect().BindNamed("SELECT id # :skip\nFROM t WHERE id = :id", device{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SELECT id # :skip\nFROM t WHERE id = ?"; q != want || len(args) != 1 {
+		t.Fatalf("BindNamed = %q, %v, 期望 %q", q, args, want)
+	}
+	if _, _, err := d.BindNamed("SELECT id # :skip", device{}); err == nil {
+		t.Fatal("Oracle 中 # 不是注释, 应返回缺少参数的错误")
+	}
+}
+
+func TestSQLClientContext(t *testing.T) {
+	p := &db.SQLite{DriverName: "sqlite", DataSourceName: ":memory:", SQLBase: db.SQLBase{MaxOpenConns: 1, MaxIdleConns: 1}}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	c := p.Client()
+	defer c.Close()
+	ctx := context.Background()
+	if _, err := p.ExecContext(ctx, "CREATE TABLE device (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ExecContext(ctx, "INSERT INTO device VALUES (?, ?)", 1, "d1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ExecNamed(ctx, "INSERT INTO device VALUES (:id, :name)", map[string]interface{}{"id": 2, "name": "d2"}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := p.GetSQLContext(ctx, "SELECT name FROM device WHERE id = :id", sql.Named("id", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["name"] != "d2" {
+		t.Fatalf("查询结果 %v", rows)
+	}
+	if _, err := p.GetSQLContext(ctx, "SELECT name FROM device WHERE id = ? AND name = :name", 1, sql.Named("name", "d1")); err == nil {
+		t.Fatal("混用位置参数和命名参数时应返回错误")
+	}
+	_, err = p.GetSQLContext(ctx, "SELECT name FROM device WHERE id = :id", sql.Named("id", 1), sql.Named("id", 2))
+	if err == nil || !strings.Contains(err.Error(), "重复") {
+		t.Fatalf("命名参数重复时应返回重复的错误, 实际 %v", err)
+	}
+	rows, err = c.QueryNamed(ctx, "SELECT name FROM device WHERE id = :id", map[string]interface{}{"id": 1})
+	if err != nil || len(rows) != 1 || rows[0]["name"] != "d1" {
+		t.Fatalf("QueryNamed = %v, %v", rows, err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := p.GetSQLContext(cancelled, "SELECT name FROM device WHERE id = ?", 1); err == nil {
+		t.Fatal("ctx 已取消时应返回错误")
+	}
+}
@@ -0,0 +1,19 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// openGorm 按数据源和连接池配置打开 gorm 连接, gorm 的方言名称与驱动名称相同.
+func openGorm(src SQLSource, pool SQLPool) (*gorm.DB, error) {
+	db, err := openDB(src, pool)
+	if err != nil {
+		return nil, err
+	}
+	g, err := gorm.Open(src.Dialect().Driver, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return g, nil
+}
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrStop is ForEach 的回调返回该错误时停止遍历, ForEach 返回 nil.
+var ErrStop = errors.New("停止遍历")
+
+// RowIterator is 逐行读取查询结果的游标, 不会一次加载全部结果, 适合导出等大结果集场景.
+// 使用完毕或提前结束时需调用 Close 释放连接.
+//
+//	it, err := client.Rows(ctx, "SELECT id, name FROM device WHERE status = ?", 1)
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		row, err := it.Row()
+//		...
+//	}
+//	return it.Err()
+type RowIterator struct {
+	rows    *sql.Rows
+	columns []string
+	types   []*sql.ColumnType
+	values  []interface{}
+	ptrs    []interface{}
+	err     error
+}
+
+// Rows is 执行查询并返回逐行读取结果的游标, 参数规则同 QueryContext.
+func (c *SQLClient) Rows(ctx context.Context, query string, args ...interface{}) (*RowIterator, error) {
+	if c == nil {
+		return nil, errSQLNotInit
+	}
+	query, args, err := c.bind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return NewRowIterator(rows)
+}
+
+// NewRowIterator is 使用 rows 创建游标, 创建失败时关闭 rows.
+func NewRowIterator(rows *sql.Rows) (*RowIterator, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	it := &RowIterator{
+		rows:    rows,
+		columns: columns,
+		types:   types,
+		values:  make([]interface{}, len(columns)),
+		ptrs:    make([]interface{}, len(columns)),
+	}
+	for i := range it.ptrs {
+		it.ptrs[i] = &it.values[i]
+	}
+	return it, nil
+}
+
+// Columns is 返回列名.
+func (it *RowIterator) Columns() []string {
+	return it.columns
+}
+
+// ColumnTypes is 返回列类型.
+func (it *RowIterator) ColumnTypes() []*sql.ColumnType {
+	return it.types
+}
+
+// Next is 移动到下一行, 没有更多行或出错时返回 false 并关闭游标, 错误通过 Err 获取.
+func (it *RowIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	return it.rows.Next()
+}
+
+// Scan is 将当前行读取到 dest, 用法同 sql.Rows.Scan, 读取失败时关闭游标,
+// 因此 Values、Row、Batch 出错时游标也已关闭.
+func (it *RowIterator) Scan(dest ...interface{}) error {
+	if err := it.rows.Scan(dest...); err != nil {
+		it.err = err
+		it.rows.Close()
+		return err
+	}
+	return nil
+}
+
+// scan 读取当前行的原始值.
+func (it *RowIterator) scan() error {
+	return it.Scan(it.ptrs...)
+}
+
+// Values is 按列顺序返回当前行的值, 值按列的数据库类型转换,
+// 整数为 int64, 无符号整数为 uint64, 浮点数为 float64, 二进制为 []byte, 其他文本 (包括 DECIMAL) 为 string.
+func (it *RowIterator) Values() ([]interface{}, error) {
+	if err := it.scan(); err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(it.values))
+	for i, v := range it.values {
+		values[i] = typedValue(v, it.types[i])
+	}
+	return values, nil
+}
+
+// Row is 返回当前行列名到值的映射, 值的类型同 Values.
+func (it *RowIterator) Row() (map[string]interface{}, error) {
+	values, err := it.Values()
+	if err != nil {
+		return nil, err
+	}
+	row := make(map[string]interface{}, len(values))
+	for i, column := range it.columns {
+		row[column] = values[i]
+	}
+	return row, nil
+}
+
+// Batch is 读取至多 n 行, 返回的行数小于 n 时表示结果已读完, 读完后再调用返回空切片.
+// n 小于等于 0 时不读取, 返回空切片.
+func (it *RowIterator) Batch(n int) ([]map[string]interface{}, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	batch := make([]map[string]interface{}, 0, n)
+	for len(batch) < n && it.Next() {
+		row, err := it.Row()
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, row)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// ForEach is 对剩余的每一行调用 fn, 结束后关闭游标.
+// fn 返回 ErrStop 时停止遍历并返回 nil, 返回其他错误时停止遍历并返回该错误.
+func (it *RowIterator) ForEach(fn func(row map[string]interface{}) error) error {
+	defer it.Close()
+	for it.Next() {
+		row, err := it.Row()
+		if err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			if err == ErrStop {
+				return nil
+			}
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// Err is 返回遍历或读取过程中的错误.
+func (it *RowIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+// Close is 关闭游标并释放连接, 可以多次调用.
+func (it *RowIterator) Close() error {
+	return it.rows.Close()
+}
+
+// typedValue 按列的数据库类型转换驱动返回的 []byte, 如 MySQL 文本协议中的数字.
+func typedValue(v interface{}, ct *sql.ColumnType) interface{} {
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+	return convertBytes(b, ct.DatabaseTypeName())
+}
+
+// convertBytes 按数据库类型名 typ 转换 b, 类型名取自 MySQL、Oracle、SQLite 驱动,
+// 无符号整数 (MySQL 的 UNSIGNED 类型) 为 uint64, 无法转换或未知类型时返回 string.
+func convertBytes(b []byte, typ string) interface{} {
+	typ = strings.ToUpper(typ)
+	unsigned := strings.HasPrefix(typ, "UNSIGNED ")
+	switch strings.TrimPrefix(typ, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR":
+		if unsigned {
+			if n, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+				return n
+			}
+		} else if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			return n
+		}
+	case "FLOAT", "DOUBLE", "REAL", "BINARY_FLOAT", "BINARY_DOUBLE":
+		if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+			return f
+		}
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "RAW", "LONG RAW", "BIT", "GEOMETRY":
+		return b
+	}
+	return string(b)
+}
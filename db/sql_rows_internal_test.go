@@ -0,0 +1,33 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvertBytes(t *testing.T) {
+	tests := []struct {
+		typ  string
+		in   string
+		want interface{}
+	}{
+		{"INT", "42", int64(42)},
+		{"bigint", "-7", int64(-7)},
+		{"UNSIGNED BIGINT", "18446744073709551615", uint64(18446744073709551615)},
+		{"UNSIGNED TINYINT", "1", uint64(1)},
+		{"INTEGER", "x", "x"},
+		{"DOUBLE", "1.5", 1.5},
+		{"BINARY_FLOAT", "2.5", 2.5},
+		{"DECIMAL", "1.10", "1.10"},
+		{"POINT", "1", "1"},
+		{"INTERVAL", "1", "1"},
+		{"VARCHAR", "abc", "abc"},
+		{"BLOB", "\x00\x01", []byte{0, 1}},
+		{"LONG RAW", "a", []byte("a")},
+	}
+	for _, tt := range tests {
+		if got := convertBytes([]byte(tt.in), tt.typ); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("convertBytes(%q, %s) = %#v, 期望 %#v", tt.in, tt.typ, got, tt.want)
+		}
+	}
+}
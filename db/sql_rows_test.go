@@ -0,0 +1,110 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/zhgqiang/commongo/db"
+)
+
+func TestRowIterator(t *testing.T) {
+	p := &db.SQLite{DriverName: "sqlite", DataSourceName: ":memory:", SQLBase: db.SQLBase{MaxOpenConns: 1, MaxIdleConns: 1}}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	c := p.Client()
+	defer c.Close()
+	ctx := context.Background()
+	if _, err := c.Exec("CREATE TABLE device (id INTEGER PRIMARY KEY, name TEXT, value REAL)"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		if _, err := c.Exec("INSERT INTO device VALUES (?, ?, ?)", i, "d", float64(i)/2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it, err := p.GetSQLRows(ctx, "SELECT id, name, value FROM device ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch, err := it.Batch(0); err != nil || len(batch) != 0 {
+		t.Fatalf("Batch(0) = %v, %v", batch, err)
+	}
+	if batch, err := it.Batch(-1); err != nil || len(batch) != 0 {
+		t.Fatalf("Batch(-1) = %v, %v", batch, err)
+	}
+	var sizes []int
+	for {
+		batch, err := it.Batch(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		sizes = append(sizes, len(batch))
+	}
+	it.Close()
+	if len(sizes) != 3 || sizes[2] != 1 {
+		t.Fatalf("批次大小 %v", sizes)
+	}
+
+	it, err = c.Rows(ctx, "SELECT id, name, value FROM device ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	err = it.ForEach(func(row map[string]interface{}) error {
+		id, ok := row["id"].(int64)
+		if !ok {
+			t.Fatalf("id 类型为 %T", row["id"])
+		}
+		if _, ok := row["value"].(float64); !ok {
+			t.Fatalf("value 类型为 %T", row["value"])
+		}
+		ids = append(ids, id)
+		if id == 2 {
+			return db.ErrStop
+		}
+		return nil
+	})
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("ForEach = %v, ids %v", err, ids)
+	}
+
+	it, err = c.Rows(ctx, "SELECT id FROM device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail := errors.New("失败")
+	if err := it.ForEach(func(map[string]interface{}) error { return fail }); err != fail {
+		t.Fatalf("ForEach = %v, 期望返回 fn 的错误", err)
+	}
+	// 游标已关闭, 连接应已释放.
+	if _, err := c.Query("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 读取失败时游标关闭, 不调用 Close 也释放连接.
+	it, err = c.Rows(ctx, "SELECT id FROM device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	var a, b int64
+	if err := it.Scan(&a, &b); err == nil {
+		t.Fatal("列数不符时 Scan 应返回错误")
+	}
+	if it.Next() || it.Err() == nil {
+		t.Fatal("读取失败后 Next 应返回 false, Err 返回该错误")
+	}
+	if _, err := c.Query("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+}
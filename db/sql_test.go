@@ -0,0 +1,54 @@
+package db_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/zhgqiang/commongo/db"
+)
+
+func TestSQLClient(t *testing.T) {
+	p := &db.SQLite{DriverName: "sqlite", DataSourceName: ":memory:", SQLBase: db.SQLBase{MaxOpenConns: 1, MaxIdleConns: 1}}
+	if _, err := p.GetSQL("SELECT 1"); err == nil {
+		t.Fatal("未初始化时应返回错误")
+	}
+	bad := &db.SQLite{DriverName: "sqlite", DataSourceName: "/nonexistent/dir/commongo.db"}
+	if err := bad.Init(); err == nil || bad.Client() != nil {
+		t.Fatal("无法连接数据库时 Init 应返回错误")
+	}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	c := p.Client()
+	defer c.Close()
+	if _, err := c.Exec("CREATE TABLE device (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO device VALUES (1, 'd1')")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollback := errors.New("回滚")
+	err = c.Transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("INSERT INTO device VALUES (2, 'd2')"); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if err != rollback {
+		t.Fatalf("Transaction = %v, 期望返回 fn 的错误", err)
+	}
+	rows, err := p.GetSQL("SELECT id, name FROM device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["name"] != "d1" {
+		t.Fatalf("查询结果 %v", rows)
+	}
+}
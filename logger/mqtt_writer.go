@@ -2,12 +2,6 @@ package logger
 
 import (
 	"errors"
-	"fmt"
-	"net"
-	"strconv"
-
-	proto "github.com/huin/mqtt"
-	"github.com/jeffallen/mqtt"
 
 	"github.com/zhgqiang/commongo/mq"
 )
@@ -28,19 +22,8 @@ func (rl *EmqttWriter) Write(p []byte) (n int, err error) {
 	if len(p) == 0 {
 		return 0, errors.New("数据为空")
 	}
-	conn, err := net.Dial("tcp", net.JoinHostPort(rl.ops.Host, strconv.Itoa(rl.ops.Port)))
-	if err != nil {
+	if err := rl.ops.Publish(rl.topic, p); err != nil {
 		return 0, err
 	}
-	cc := mqtt.NewClientConn(conn)
-	if err := cc.Connect(rl.ops.Username, rl.ops.Password); err != nil {
-		return 0, fmt.Errorf("EMQTT连接错误.%+v", err)
-	}
-
-	cc.Publish(&proto.Publish{
-		TopicName: rl.topic,
-		Payload:   proto.BytesPayload(p),
-	})
-	cc.Disconnect()
-	return len(p), err
+	return len(p), nil
 }
@@ -0,0 +1,173 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// BRIDGE_TO_RABBIT 将 MQTT 消息转发到 RabbitMQ.
+	BRIDGE_TO_RABBIT = "mqtt2rabbit"
+	// BRIDGE_TO_MQTT 将 RabbitMQ 消息转发到 MQTT.
+	BRIDGE_TO_MQTT = "rabbit2mqtt"
+)
+
+// HeaderBridged is 桥接转发的消息携带的消息头, 带有该消息头的消息不再转发, 避免双向桥接时消息循环.
+// MQTT 3.1 不支持消息头, 双向桥接时两个方向的 topic 不能重叠.
+const HeaderBridged = "x-bridged"
+
+// defaultBridgeGroup 为 rabbit2mqtt 默认的共享订阅分组.
+const defaultBridgeGroup = "bridge"
+
+// BridgeRule is 桥接规则.
+// MQTT topic 按 RoutingKey 转换为 routing key, 包含 . 的 MQTT 消息无法转发到 RabbitMQ,
+// 转发时返回 Permanent 错误, 调用 OnError 后确认并丢弃该消息.
+type BridgeRule struct {
+	Direction string `json:"direction" toml:"direction" description:"转发方向:mqtt2rabbit、rabbit2mqtt"`
+	// Source 为 MQTT 风格的 topic 过滤器, RabbitMQ 侧按 RoutingKey 转换为绑定键.
+	Source string `json:"source" toml:"source" description:"转发来源topic过滤器"`
+	// Target 为空时使用原 topic.
+	Target string `json:"target" toml:"target" description:"转发目标topic,为空时使用原topic"`
+	// Group 为订阅来源使用的共享订阅分组, 多个桥接实例分摊消息.
+	// rabbit2mqtt 时对应持久化队列 <Exchange>.<Group>.<绑定键>, 为空时为 bridge.
+	Group string `json:"group" toml:"group" description:"共享订阅分组,rabbit2mqtt默认为bridge"`
+	// Exchange 为 RabbitMQ 侧的 exchange, mqtt2rabbit 时发送到该 exchange, rabbit2mqtt 时订阅该 exchange,
+	// 为空时使用 RabbitMQ 配置的 Exchange. 该 exchange 不会被声明, 需已存在.
+	Exchange string `json:"exchange" toml:"exchange" description:"RabbitMQ侧的exchange,为空时使用配置的Exchange"`
+}
+
+// Bridge is MQTT 与 RabbitMQ 之间的消息桥接.
+// 消息体、内容类型、关联ID、响应topic及消息头原样转发, MQTT 侧使用 QoS 1,
+// RabbitMQ 侧持久化消息. 转发成功后才确认来源消息, 失败时按退避间隔重试,
+// 停止时正在转发的消息不确认, 由 broker 重新投递, 因此消息至少转发一次, 可能重复.
+// 为此 MQTT 应配置固定 ClientID 及 SessionExpiry 使用持久会话, 并先调用 Connect;
+// RabbitMQ 应开启 Confirm, 通常使用 RabbitPublisher 复用连接.
+type Bridge struct {
+	MQTT   PubSub
+	Rabbit PubSub
+	Rules  []BridgeRule
+
+	// OnError 不为空时在转发失败时调用, 用于记录日志.
+	OnError func(rule BridgeRule, err error)
+}
+
+// NewBridge is 创建桥接.
+func NewBridge(mqtt, rabbit PubSub, rules ...BridgeRule) *Bridge {
+	return &Bridge{MQTT: mqtt, Rabbit: rabbit, Rules: rules}
+}
+
+// Run is 按规则开始转发, 阻塞直到 ctx 结束或任意规则出错, 出错时停止所有规则并返回该错误.
+// 规则有误时不启动任何转发.
+func (b *Bridge) Run(ctx context.Context) error {
+	for _, r := range b.Rules {
+		if r.Direction != BRIDGE_TO_RABBIT && r.Direction != BRIDGE_TO_MQTT {
+			return fmt.Errorf("不支持的桥接方向%s", r.Direction)
+		}
+		if r.Source == "" {
+			return errors.New("桥接来源不能为空")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, len(b.Rules))
+	var wg sync.WaitGroup
+	for _, r := range b.Rules {
+		r := r
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.forward(ctx, r); err != nil && ctx.Err() == nil {
+				errc <- err
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	select {
+	case err := <-errc:
+		return err
+	default:
+		return nil
+	}
+}
+
+// forward 按规则 r 订阅来源并转发到目标.
+func (b *Bridge) forward(ctx context.Context, r BridgeRule) error {
+	src, dst := b.MQTT, b.Rabbit
+	group := r.Group
+	var pubOpts []PublishOption
+	subOpts := []SubscribeOption{WithSubscribeQos(1), WithNoAckOnError()}
+	if r.Direction == BRIDGE_TO_MQTT {
+		src, dst = b.Rabbit, b.MQTT
+		if group == "" {
+			group = defaultBridgeGroup
+		}
+		if r.Exchange != "" {
+			subOpts = append(subOpts, WithSubscribeExchange(r.Exchange))
+		}
+	} else if r.Exchange != "" {
+		pubOpts = append(pubOpts, WithExchange(r.Exchange))
+	}
+	h := func(ctx context.Context, msg *Message) error {
+		if msg.Headers[HeaderBridged] != "" {
+			return nil
+		}
+		topic := r.Target
+		if topic == "" {
+			topic = msg.Topic
+		}
+		opts := append(bridgeOptions(msg), WithQos(1), WithPersistent(true), WithExpiry(msg.Expiry))
+		opts = append(opts, pubOpts...)
+		return b.retry(ctx, r, func() error {
+			return dst.Publish(topic, msg.Payload, opts...)
+		})
+	}
+	if group != "" {
+		subOpts = append(subOpts, WithShareGroup(group))
+	}
+	return src.Subscribe(ctx, r.Source, h, subOpts...)
+}
+
+// bridgeOptions 返回转发 msg 时保留其属性的发送选项.
+func bridgeOptions(msg *Message) []PublishOption {
+	opts := []PublishOption{
+		WithContentType(msg.ContentType),
+		WithCorrelationID(msg.CorrelationID),
+		WithReplyTo(msg.ResponseTopic),
+		WithHeader(HeaderBridged, "1"),
+	}
+	for k, v := range msg.Headers {
+		opts = append(opts, WithHeader(k, v))
+	}
+	return opts
+}
+
+// retry 重复执行 fn 直到成功或 ctx 结束, fn 返回 Permanent 错误时不再重试并丢弃消息.
+func (b *Bridge) retry(ctx context.Context, r BridgeRule, fn func() error) error {
+	backoff := 100 * time.Millisecond
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if b.OnError != nil {
+			b.OnError(r, err)
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 10*time.Second {
+			backoff = 10 * time.Second
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package mq_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zhgqiang/commongo/mq"
+)
+
+func TestBridge(t *testing.T) {
+	mqtt, rabbit := mq.NewMemoryBroker(), mq.NewMemoryBroker()
+	defer mqtt.Close()
+	defer rabbit.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 两个方向的 topic 重叠, 由 HeaderBridged 避免消息循环
+	b := mq.NewBridge(mqtt, rabbit,
+		mq.BridgeRule{Direction: mq.BRIDGE_TO_RABBIT, Source: "dev/#"},
+		mq.BridgeRule{Direction: mq.BRIDGE_TO_MQTT, Source: "dev/#"},
+		mq.BridgeRule{Direction: mq.BRIDGE_TO_RABBIT, Source: "cmd/+", Target: "commands"},
+	)
+	errc := make(chan error, 1)
+	go func() { errc <- b.Run(ctx) }()
+	fromMQTT := subscribe(t, ctx, mqtt, "#")
+	fromRabbit := subscribe(t, ctx, rabbit, "#")
+	time.Sleep(50 * time.Millisecond)
+
+	if err := mqtt.Publish("dev/1", []byte("a"), mq.WithHeader("tenant", "t1"), mq.WithContentType("text/plain")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rabbit.Publish("dev/2", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mqtt.Publish("cmd/x", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	collect := func(ch <-chan *mq.Message) map[string]*mq.Message {
+		got := make(map[string]*mq.Message)
+		for {
+			select {
+			case msg := <-ch:
+				if got[msg.Topic] != nil {
+					t.Fatalf("%s 收到重复消息, 桥接产生了循环", msg.Topic)
+				}
+				got[msg.Topic] = msg
+			case <-time.After(200 * time.Millisecond):
+				return got
+			}
+		}
+	}
+	got := collect(fromRabbit)
+	if len(got) != 3 || got["dev/1"] == nil || got["dev/2"] == nil || got["commands"] == nil {
+		t.Fatalf("RabbitMQ 侧收到 %v", got)
+	}
+	msg := got["dev/1"]
+	if string(msg.Payload) != "a" || msg.ContentType != "text/plain" ||
+		msg.Headers["tenant"] != "t1" || msg.Headers[mq.HeaderBridged] == "" {
+		t.Fatalf("转发的消息属性错误: %+v", msg)
+	}
+	got = collect(fromMQTT)
+	if len(got) != 3 || got["dev/1"] == nil || got["dev/2"] == nil || got["cmd/x"] == nil {
+		t.Fatalf("MQTT 侧收到 %v", got)
+	}
+
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countingBroker 记录订阅次数.
+type countingBroker struct {
+	mq.PubSub
+	subscribes int32
+}
+
+func (b *countingBroker) Subscribe(ctx context.Context, topic string, h mq.Handler, opts ...mq.SubscribeOption) error {
+	atomic.AddInt32(&b.subscribes, 1)
+	return b.PubSub.Subscribe(ctx, topic, h, opts...)
+}
+
+func TestBridge_InvalidRule(t *testing.T) {
+	mqtt := &countingBroker{PubSub: mq.NewMemoryBroker()}
+	rabbit := &countingBroker{PubSub: mq.NewMemoryBroker()}
+	b := mq.NewBridge(mqtt, rabbit,
+		mq.BridgeRule{Direction: mq.BRIDGE_TO_RABBIT, Source: "dev/#"},
+		mq.BridgeRule{Direction: "unknown", Source: "dev/#"},
+	)
+	if err := b.Run(context.Background()); err == nil {
+		t.Fatal("桥接方向错误时应返回错误")
+	}
+	if n := atomic.LoadInt32(&mqtt.subscribes) + atomic.LoadInt32(&rabbit.subscribes); n != 0 {
+		t.Fatalf("规则有误时不应启动转发, 实际订阅了 %d 次", n)
+	}
+}
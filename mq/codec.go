@@ -0,0 +1,307 @@
+package mq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// JSON is JSON 编码.
+	JSON = "json"
+	// MSGPACK is MessagePack 编码.
+	MSGPACK = "msgpack"
+	// CBOR is CBOR 编码.
+	CBOR = "cbor"
+	// PROTOBUF is Protobuf 编码, 消息必须实现 proto.Message.
+	PROTOBUF = "protobuf"
+	// TEXT is 纯文本, 即 RabbitMQ.Send 发送的 text/plain 消息.
+	TEXT = "text"
+
+	// GZIP is gzip 压缩.
+	GZIP = "gzip"
+	// ZSTD is zstd 压缩.
+	ZSTD = "zstd"
+)
+
+// Codec is 消息编解码器.
+type Codec interface {
+	// Name 编码名称, 用于配置中选择编码器.
+	Name() string
+	// ContentType 编码对应的 MIME 类型, 随消息发送以便消费者自动解码.
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	codecMu sync.RWMutex
+	codecs  = make(map[string]Codec)
+)
+
+func init() {
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(msgpackCodec{})
+	RegisterCodec(cborCodec{})
+	RegisterCodec(protobufCodec{})
+	RegisterCodec(textCodec{})
+}
+
+// RegisterCodec is 注册编解码器, 可按名称或 ContentType 查找.
+func RegisterCodec(c Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	codecs[c.Name()] = c
+	codecs[c.ContentType()] = c
+}
+
+// GetCodec is 按名称或 ContentType 查找编解码器, name 为空时返回 JSON 编解码器.
+// ContentType 的参数被忽略, 如 application/json; charset=utf-8.
+func GetCodec(name string) (Codec, error) {
+	if name == "" {
+		name = JSON
+	}
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		if i := strings.IndexByte(name, ';'); i > 0 {
+			c, ok = codecs[strings.TrimSpace(name[:i])]
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("不支持的编码%s", name)
+	}
+	return c, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return JSON }
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string        { return MSGPACK }
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string        { return CBOR }
+func (cborCodec) ContentType() string { return "application/cbor" }
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	return cbor.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string        { return PROTOBUF }
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf编码需要proto.Message,实际为%T", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf解码需要proto.Message,实际为%T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+type textCodec struct{}
+
+func (textCodec) Name() string        { return TEXT }
+func (textCodec) ContentType() string { return "text/plain" }
+
+func (textCodec) Marshal(v interface{}) ([]byte, error) {
+	switch s := v.(type) {
+	case string:
+		return []byte(s), nil
+	case []byte:
+		return s, nil
+	}
+	return nil, fmt.Errorf("text编码需要string或[]byte,实际为%T", v)
+}
+
+// Unmarshal 解码到 *string 或 *[]byte, 其他类型按 JSON 解码, 兼容以 text/plain 发送的 JSON.
+func (textCodec) Unmarshal(data []byte, v interface{}) error {
+	switch p := v.(type) {
+	case *string:
+		*p = string(data)
+		return nil
+	case *[]byte:
+		*p = append((*p)[:0], data...)
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+// Compress is 使用 GZIP 或 ZSTD 压缩数据, encoding 为空时原样返回.
+func Compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case "":
+		return data, nil
+	case GZIP:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case ZSTD:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+	return nil, fmt.Errorf("不支持的压缩方式%s", encoding)
+}
+
+// Decompress is 解压 Compress 压缩的数据.
+func Decompress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case "":
+		return data, nil
+	case GZIP:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case ZSTD:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(data, nil)
+	}
+	return nil, fmt.Errorf("不支持的压缩方式%s", encoding)
+}
+
+// EncodePayload is 使用 codec 编码 v 并按 encoding 压缩.
+func EncodePayload(codec Codec, encoding string, v interface{}) ([]byte, error) {
+	b, err := codec.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("消息编码失败.%v", err)
+	}
+	return Compress(encoding, b)
+}
+
+// DecodePayload is 按 contentType 和 encoding 解压并解码消息, contentType 为空时按 JSON 解码.
+func DecodePayload(contentType, encoding string, data []byte, v interface{}) error {
+	codec, err := GetCodec(contentType)
+	if err != nil {
+		return err
+	}
+	b, err := Decompress(encoding, data)
+	if err != nil {
+		return fmt.Errorf("消息解压失败.%v", err)
+	}
+	return codec.Unmarshal(b, v)
+}
+
+// payloadMagic 标记消息带有内容类型头, 以 0x00 开头且不是合法的 UTF-8 文本.
+const payloadMagic = "\x00\xffMQ"
+
+var errBadTag = errors.New("消息内容类型头格式错误")
+
+// TagPayload is 在消息前添加内容类型头, 用于不支持消息属性的 MQTT 3.1.
+// 格式为 4 字节标记 00 ff 4d 51、contentType 长度、contentType、encoding 长度、encoding, 之后为消息体,
+// contentType 和 encoding 不能超过 255 字节.
+func TagPayload(contentType, encoding string, data []byte) ([]byte, error) {
+	if len(contentType) > 255 || len(encoding) > 255 {
+		return nil, fmt.Errorf("消息内容类型头超过255字节.%s,%s", contentType, encoding)
+	}
+	b := make([]byte, 0, len(payloadMagic)+2+len(contentType)+len(encoding)+len(data))
+	b = append(b, payloadMagic...)
+	b = append(b, byte(len(contentType)))
+	b = append(b, contentType...)
+	b = append(b, byte(len(encoding)))
+	b = append(b, encoding...)
+	return append(b, data...), nil
+}
+
+// UntagPayload is 解析 TagPayload 添加的内容类型头, 没有头时 contentType 返回空.
+func UntagPayload(data []byte) (contentType, encoding string, body []byte, err error) {
+	if !bytes.HasPrefix(data, []byte(payloadMagic)) {
+		return "", "", data, nil
+	}
+	data = data[len(payloadMagic):]
+	var fields [2]string
+	for i := range fields {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return "", "", nil, errBadTag
+		}
+		n := int(data[0])
+		fields[i] = string(data[1 : 1+n])
+		data = data[1+n:]
+	}
+	return fields[0], fields[1], data, nil
+}
+
+// DecodeTaggedPayload is 按内容类型头自动解码消息, 没有头时按 JSON 解码.
+func DecodeTaggedPayload(data []byte, v interface{}) error {
+	contentType, encoding, body, err := UntagPayload(data)
+	if err != nil {
+		return err
+	}
+	return DecodePayload(contentType, encoding, body, v)
+}
@@ -0,0 +1,73 @@
+package mq_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zhgqiang/commongo/mq"
+)
+
+func TestDecodeTaggedPayload(t *testing.T) {
+	type telemetry struct {
+		Device string  `json:"device" msgpack:"device" cbor:"device"`
+		Value  float64 `json:"value" msgpack:"value" cbor:"value"`
+	}
+	in := telemetry{Device: "d1", Value: 1.5}
+	for _, name := range []string{mq.JSON, mq.MSGPACK, mq.CBOR} {
+		for _, encoding := range []string{"", mq.GZIP, mq.ZSTD} {
+			codec, err := mq.GetCodec(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := mq.EncodePayload(codec, encoding, in)
+			if err != nil {
+				t.Fatal(name, encoding, err)
+			}
+			tagged, err := mq.TagPayload(codec.ContentType(), encoding, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out telemetry
+			if err := mq.DecodeTaggedPayload(tagged, &out); err != nil {
+				t.Fatal(name, encoding, err)
+			}
+			if out != in {
+				t.Fatalf("%s/%s 解码结果不一致: %+v", name, encoding, out)
+			}
+		}
+	}
+}
+
+func TestUntagPayload(t *testing.T) {
+	// msgpack 编码的 0 为 0x00, 没有内容类型头时应原样返回
+	b, err := mq.EncodePayload(mustCodec(t, mq.MSGPACK), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contentType, _, body, err := mq.UntagPayload(b)
+	if err != nil || contentType != "" || string(body) != string(b) {
+		t.Fatalf("UntagPayload(%x) = %q, %x, %v", b, contentType, body, err)
+	}
+	if _, err := mq.TagPayload(strings.Repeat("a", 256), "", nil); err == nil {
+		t.Fatal("内容类型超过255字节时应返回错误")
+	}
+}
+
+func TestDecodePayload_Text(t *testing.T) {
+	var s string
+	if err := mq.DecodePayload("text/plain", "", []byte("hello"), &s); err != nil || s != "hello" {
+		t.Fatalf("DecodePayload = %q, %v", s, err)
+	}
+	var m map[string]int
+	if err := mq.DecodePayload("text/plain; charset=utf-8", "", []byte(`{"a":1}`), &m); err != nil || m["a"] != 1 {
+		t.Fatalf("DecodePayload = %v, %v", m, err)
+	}
+}
+
+func mustCodec(t *testing.T, name string) mq.Codec {
+	c, err := mq.GetCodec(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
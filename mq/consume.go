@@ -0,0 +1,85 @@
+package mq
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// consume 从 sub 读取消息交给 h 处理, 阻塞直到 ctx 结束或连接断开.
+// 返回前等待正在处理的消息完成, 尚未开始处理的消息不会被确认;
+// 处理失败的消息支持拒绝时拒绝, 否则指定 WithNoAckOnError 时不确认.
+// MQTT 3.1 连接下并发处理的消息可能乱序确认, MQTT 5 连接会按收到的顺序确认.
+func consume(ctx context.Context, c notifier, sub *emqttSub, h Handler, o *subscribeOptions) error {
+	workers := o.workers
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// 未指定 key 时所有协程共用一个队列, 否则每个协程一个队列
+	queues := make([]chan *inbound, workers)
+	for i := range queues {
+		if o.orderKey == nil && i > 0 {
+			queues[i] = queues[0]
+			continue
+		}
+		queues[i] = make(chan *inbound)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(q chan *inbound) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case in := <-q:
+					if err := h(ctx, in.msg); err != nil {
+						if in.nackFn != nil {
+							in.nackFn()
+							continue
+						}
+						if o.noAck {
+							continue
+						}
+					}
+					in.ack()
+				}
+			}
+		}(queues[i])
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.done():
+			return c.closeErr()
+		case in := <-sub.ch:
+			q := queues[0]
+			if o.orderKey != nil && workers > 1 {
+				q = queues[keyIndex(o.orderKey(in.msg), workers)]
+			}
+			select {
+			case q <- in:
+			case <-ctx.Done():
+				return nil
+			case <-c.done():
+				return c.closeErr()
+			}
+		}
+	}
+}
+
+// keyIndex 将 key 映射到 [0, n).
+func keyIndex(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
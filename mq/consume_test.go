@@ -0,0 +1,113 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClient 为只用于 consume 的空连接.
+type fakeClient struct {
+	closed chan struct{}
+}
+
+func (c *fakeClient) publish(string, []byte, *publishOptions) error          { return nil }
+func (c *fakeClient) subscribe(string, *subscribeOptions) (*emqttSub, error) { return nil, nil }
+func (c *fakeClient) unsubscribe(string) error                               { return nil }
+func (c *fakeClient) done() <-chan struct{}                                  { return c.closed }
+func (c *fakeClient) closeErr() error                                        { return errConnClosed }
+func (c *fakeClient) close() error                                           { return nil }
+
+func TestConsume_OrderKey(t *testing.T) {
+	const keys, perKey = 4, 50
+	c := &fakeClient{closed: make(chan struct{})}
+	var r subRouter
+	sub, _ := r.add("dev/+")
+
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	var acked sync.WaitGroup
+	acked.Add(keys * perKey)
+	h := func(ctx context.Context, msg *Message) error {
+		var n int
+		fmt.Sscan(string(msg.Payload), &n)
+		mu.Lock()
+		got[msg.Topic] = append(got[msg.Topic], n)
+		mu.Unlock()
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- consume(ctx, c, sub, h, &subscribeOptions{
+			workers:  3,
+			orderKey: func(msg *Message) string { return msg.Topic },
+		})
+	}()
+	for i := 0; i < perKey; i++ {
+		for k := 0; k < keys; k++ {
+			msg := &Message{Topic: fmt.Sprint("dev/", k), Payload: []byte(fmt.Sprint(i))}
+			r.dispatch(msg, acked.Done)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		acked.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待消息确认超时")
+	}
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	for topic, ns := range got {
+		for i, n := range ns {
+			if n != i {
+				t.Fatalf("%s 第%d条消息为%d, 顺序错误", topic, i, n)
+			}
+		}
+	}
+}
+
+func TestConsume_NoAckOnError(t *testing.T) {
+	c := &fakeClient{closed: make(chan struct{})}
+	var r subRouter
+	sub, _ := r.add("dev/+")
+	h := func(ctx context.Context, msg *Message) error {
+		if string(msg.Payload) == "bad" {
+			return fmt.Errorf("处理失败")
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go consume(ctx, c, sub, h, &subscribeOptions{noAck: true})
+
+	acked := make(chan string, 2)
+	for _, p := range []string{"bad", "good"} {
+		p := p
+		r.dispatch(&Message{Topic: "dev/1", Payload: []byte(p)}, func() { acked <- p })
+	}
+	select {
+	case p := <-acked:
+		if p != "good" {
+			t.Fatalf("处理失败的消息 %s 被确认", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("处理成功的消息未确认")
+	}
+	select {
+	case p := <-acked:
+		t.Fatalf("处理失败的消息 %s 被确认", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,267 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// DedupeState is 消息ID的处理状态.
+type DedupeState int
+
+const (
+	// DEDUPE_CLAIMED is 消息ID不存在, 已被本次调用占用.
+	DEDUPE_CLAIMED DedupeState = iota
+	// DEDUPE_PROCESSING is 消息正在由其他消费者处理.
+	DEDUPE_PROCESSING
+	// DEDUPE_DONE is 消息已处理.
+	DEDUPE_DONE
+)
+
+// ErrDedupeProcessing is 同一ID的消息正在由其他消费者处理, 处理结果未知, 应稍后重试.
+var ErrDedupeProcessing = errors.New("消息正在由其他消费者处理")
+
+// DedupeStore is 已处理消息ID的存储, 用于 Dedupe 去重.
+type DedupeStore interface {
+	// Claim 在 id 不存在时记录 id 并在 lease 后过期, 返回 DEDUPE_CLAIMED;
+	// id 已存在时返回其状态 DEDUPE_PROCESSING 或 DEDUPE_DONE.
+	Claim(ctx context.Context, id string, lease time.Duration) (DedupeState, error)
+	// Commit 标记 id 处理完成, 在 ttl 后过期.
+	Commit(ctx context.Context, id string, ttl time.Duration) error
+	// Release 删除 id, 使重新投递的消息可以再次处理.
+	Release(ctx context.Context, id string) error
+}
+
+// DedupeOption is 去重选项.
+type DedupeOption func(*dedupeOptions)
+
+// dedupeOptions 汇总去重选项.
+type dedupeOptions struct {
+	key   func(*Message) string
+	ttl   time.Duration
+	lease time.Duration
+}
+
+const (
+	defaultDedupeTTL   = 24 * time.Hour
+	defaultDedupeLease = 5 * time.Minute
+)
+
+// WithDedupeHeader is 使用消息头 name 的值作为消息ID, 默认为 HeaderMessageID.
+func WithDedupeHeader(name string) DedupeOption {
+	return func(o *dedupeOptions) {
+		o.key = func(msg *Message) string {
+			return msg.Headers[name]
+		}
+	}
+}
+
+// WithDedupeField is 使用 JSON 消息体中字段 field 的值作为消息ID, 嵌套字段以 . 分隔, 如 meta.id.
+func WithDedupeField(field string) DedupeOption {
+	path := strings.Split(field, ".")
+	return func(o *dedupeOptions) {
+		o.key = func(msg *Message) string {
+			return payloadField(msg.Payload, path)
+		}
+	}
+}
+
+// WithDedupeKey is 使用 fn 计算消息ID.
+func WithDedupeKey(fn func(*Message) string) DedupeOption {
+	return func(o *dedupeOptions) {
+		o.key = fn
+	}
+}
+
+// WithDedupeTTL is 设置已处理消息ID的保存时间, 默认 24 小时, 应大于 broker 可能重新投递的时间.
+func WithDedupeTTL(ttl time.Duration) DedupeOption {
+	return func(o *dedupeOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithDedupeLease is 设置处理中消息ID的保存时间, 默认 5 分钟.
+// 进程在处理过程中崩溃时, 重新投递的消息在 lease 过期后才会再次处理, 应大于处理一条消息的最长时间.
+func WithDedupeLease(lease time.Duration) DedupeOption {
+	return func(o *dedupeOptions) {
+		o.lease = lease
+	}
+}
+
+// Dedupe is 返回按消息ID去重的中间件, 同一ID的消息只处理一次.
+// 处理前在 store 中占用消息ID, h 返回 nil 后标记为已处理, 返回错误时删除以便重新投递后再次处理.
+// 已处理的消息不调用 h 并返回 nil; 正在由其他消费者处理的消息可能处理失败, 不调用 h 并返回
+// ErrDedupeProcessing, 由 broker 重新投递或按重试配置稍后再处理. 没有ID的消息不去重.
+// 默认从消息头 HeaderMessageID 读取ID, 发送时使用 WithMessageID 设置.
+func Dedupe(store DedupeStore, opts ...DedupeOption) Middleware {
+	o := &dedupeOptions{
+		key: func(msg *Message) string {
+			return msg.Headers[HeaderMessageID]
+		},
+		ttl:   defaultDedupeTTL,
+		lease: defaultDedupeLease,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return func(h Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			id := o.key(msg)
+			if id == "" {
+				return h(ctx, msg)
+			}
+			state, err := store.Claim(ctx, id, o.lease)
+			if err != nil {
+				return fmt.Errorf("消息%s去重失败.%v", id, err)
+			}
+			switch state {
+			case DEDUPE_DONE:
+				return nil
+			case DEDUPE_PROCESSING:
+				return ErrDedupeProcessing
+			}
+			if err := h(ctx, msg); err != nil {
+				store.Release(ctx, id)
+				return err
+			}
+			if err := store.Commit(ctx, id, o.ttl); err != nil {
+				return fmt.Errorf("消息%s去重记录失败.%v", id, err)
+			}
+			return nil
+		}
+	}
+}
+
+// payloadField 返回 JSON 消息体中 path 字段的值, 不存在时返回空字符串.
+func payloadField(payload []byte, path []string) string {
+	// 使用 json.Number 保留数字ID的原始格式
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return ""
+	}
+	for _, name := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[name]
+	}
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// RedisDedupeStore is 基于 Redis 的 DedupeStore, 多个实例共享去重记录.
+type RedisDedupeStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisDedupeStore is 创建 Redis 去重存储, client 通常由 db.Redis 的 NewClient 创建,
+// 消息ID保存为 prefix+ID, prefix 为空时为 mq:dedupe:.
+func NewRedisDedupeStore(client *redis.Client, prefix string) *RedisDedupeStore {
+	if prefix == "" {
+		prefix = "mq:dedupe:"
+	}
+	return &RedisDedupeStore{client: client, prefix: prefix}
+}
+
+// dedupeClaimScript 在键不存在时设置为 processing 并返回 claimed, 否则返回当前值.
+var dedupeClaimScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], "processing", "PX", ARGV[1], "NX") then
+	return "claimed"
+end
+return redis.call("GET", KEYS[1])`)
+
+// Claim is 占用消息ID, 已存在时返回其状态.
+func (s *RedisDedupeStore) Claim(ctx context.Context, id string, lease time.Duration) (DedupeState, error) {
+	v, err := dedupeClaimScript.Run(s.client.WithContext(ctx), []string{s.prefix + id}, lease.Milliseconds()).Result()
+	if err != nil {
+		return 0, err
+	}
+	switch v {
+	case "claimed":
+		return DEDUPE_CLAIMED, nil
+	case "done":
+		return DEDUPE_DONE, nil
+	}
+	return DEDUPE_PROCESSING, nil
+}
+
+// Commit is 标记消息ID处理完成.
+func (s *RedisDedupeStore) Commit(ctx context.Context, id string, ttl time.Duration) error {
+	return s.client.WithContext(ctx).Set(s.prefix+id, "done", ttl).Err()
+}
+
+// Release is 删除消息ID.
+func (s *RedisDedupeStore) Release(ctx context.Context, id string) error {
+	return s.client.WithContext(ctx).Del(s.prefix + id).Err()
+}
+
+// MemoryDedupeStore is 进程内的 DedupeStore, 用于单元测试或单实例部署.
+type MemoryDedupeStore struct {
+	mu     sync.Mutex
+	ids    map[string]dedupeEntry
+	purged time.Time
+}
+
+// dedupeEntry 为一个消息ID的状态及过期时间.
+type dedupeEntry struct {
+	state  DedupeState
+	expiry time.Time
+}
+
+// NewMemoryDedupeStore is 创建内存去重存储.
+func NewMemoryDedupeStore() *MemoryDedupeStore {
+	return &MemoryDedupeStore{ids: make(map[string]dedupeEntry)}
+}
+
+// Claim is 占用消息ID, 每分钟最多清理一次已过期的ID.
+func (s *MemoryDedupeStore) Claim(ctx context.Context, id string, lease time.Duration) (DedupeState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.purged) > time.Minute {
+		for k, e := range s.ids {
+			if !e.expiry.After(now) {
+				delete(s.ids, k)
+			}
+		}
+		s.purged = now
+	}
+	if e, ok := s.ids[id]; ok && e.expiry.After(now) {
+		return e.state, nil
+	}
+	s.ids[id] = dedupeEntry{state: DEDUPE_PROCESSING, expiry: now.Add(lease)}
+	return DEDUPE_CLAIMED, nil
+}
+
+// Commit is 标记消息ID处理完成.
+func (s *MemoryDedupeStore) Commit(ctx context.Context, id string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids[id] = dedupeEntry{state: DEDUPE_DONE, expiry: time.Now().Add(ttl)}
+	return nil
+}
+
+// Release is 删除消息ID.
+func (s *MemoryDedupeStore) Release(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ids, id)
+	return nil
+}
@@ -0,0 +1,98 @@
+package mq_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+
+	"github.com/zhgqiang/commongo/mq"
+)
+
+func TestDedupe(t *testing.T) {
+	s := miniredis.RunT(t)
+	stores := map[string]mq.DedupeStore{
+		"memory": mq.NewMemoryDedupeStore(),
+		"redis":  mq.NewRedisDedupeStore(redis.NewClient(&redis.Options{Addr: s.Addr()}), ""),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			var handled []string
+			fail := true
+			h := mq.Dedupe(store, mq.WithDedupeField("meta.id"))(func(ctx context.Context, msg *mq.Message) error {
+				handled = append(handled, string(msg.Payload))
+				if fail {
+					fail = false
+					return errors.New("处理失败")
+				}
+				return nil
+			})
+			payloads := []string{
+				`{"meta":{"id":1001}}`,
+				`{"meta":{"id":1001}}`,
+				`{"meta":{"id":1001}}`,
+				`{"meta":{"id":"a"}}`,
+				`{"other":1}`,
+				`{"other":1}`,
+			}
+			for _, p := range payloads {
+				h(ctx, &mq.Message{Payload: []byte(p)})
+			}
+			// 第一次失败后重新投递的消息再次处理, 之后的重复消息跳过, 没有ID的消息不去重
+			want := []string{payloads[0], payloads[1], payloads[3], payloads[4], payloads[5]}
+			if len(handled) != len(want) {
+				t.Fatalf("处理的消息 %v, 期望 %v", handled, want)
+			}
+			for i := range want {
+				if handled[i] != want[i] {
+					t.Fatalf("处理的消息 %v, 期望 %v", handled, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupe_Processing(t *testing.T) {
+	s := miniredis.RunT(t)
+	stores := map[string]mq.DedupeStore{
+		"memory": mq.NewMemoryDedupeStore(),
+		"redis":  mq.NewRedisDedupeStore(redis.NewClient(&redis.Options{Addr: s.Addr()}), ""),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, want := range []mq.DedupeState{mq.DEDUPE_CLAIMED, mq.DEDUPE_PROCESSING} {
+				if state, err := store.Claim(ctx, "m1", time.Minute); err != nil || state != want {
+					t.Fatalf("Claim = %v, %v, 期望 %v", state, err, want)
+				}
+			}
+
+			// 消息处理期间重复投递的消息返回可重试的错误, 处理完成后跳过
+			msg := &mq.Message{Headers: map[string]string{mq.HeaderMessageID: "m2"}}
+			var handled int
+			var h mq.Handler
+			h = mq.Dedupe(store)(func(ctx context.Context, msg *mq.Message) error {
+				handled++
+				if handled == 1 {
+					if err := h(ctx, msg); !errors.Is(err, mq.ErrDedupeProcessing) {
+						t.Fatalf("处理中的消息应返回 ErrDedupeProcessing, 实际 %v", err)
+					}
+				}
+				return nil
+			})
+			if err := h(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+			if err := h(ctx, msg); err != nil || handled != 1 {
+				t.Fatalf("已处理的消息应跳过: %v, 处理了%d次", err, handled)
+			}
+			if state, err := store.Claim(ctx, "m2", time.Minute); err != nil || state != mq.DEDUPE_DONE {
+				t.Fatalf("Claim = %v, %v, 期望 DEDUPE_DONE", state, err)
+			}
+		})
+	}
+}
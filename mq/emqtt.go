@@ -2,106 +2,372 @@
 package mq
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"net"
-	"strconv"
+	"math/rand"
+	"sync"
+	"time"
 
 	proto "github.com/huin/mqtt"
-	"github.com/jeffallen/mqtt"
 )
 
 // Emqtt is 配置信息.
 type Emqtt struct {
-	Host      string `json:"host" toml:"host" description:"EMQTT地址"`
-	Port      int    `json:"port" toml:"port" description:"EMQTT端口"`
-	Username  string `json:"username" toml:"username" description:"EMQTT访问用户名"`
-	Password  string `json:"password" toml:"password" description:"EMQTT访问密码"`
-	TopicName string `json:"topicName" toml:"topicName" description:"EMQTT TopicName"`
+	Host         string `json:"host" toml:"host" description:"EMQTT地址"`
+	Port         int    `json:"port" toml:"port" description:"EMQTT端口"`
+	Username     string `json:"username" toml:"username" description:"EMQTT访问用户名"`
+	Password     string `json:"password" toml:"password" description:"EMQTT访问密码"`
+	TopicName    string `json:"topicName" toml:"topicName" description:"EMQTT TopicName"`
+	ClientID     string `json:"clientId" toml:"clientId" description:"EMQTT客户端ID,为空时随机生成"`
+	KeepAlive    int    `json:"keepAlive" toml:"keepAlive" description:"心跳间隔,单位秒"`
+	Timeout      int    `json:"timeout" toml:"timeout" description:"连接及确认超时时间,单位秒"`
+	WillTopic    string `json:"willTopic" toml:"willTopic" description:"遗嘱消息topic"`
+	WillPayload  string `json:"willPayload" toml:"willPayload" description:"遗嘱消息内容,如offline"`
+	WillQos      byte   `json:"willQos" toml:"willQos" description:"遗嘱消息QoS,0或1"`
+	WillRetain   bool   `json:"willRetain" toml:"willRetain" description:"遗嘱消息是否保留"`
+	BirthPayload string `json:"birthPayload" toml:"birthPayload" description:"连接成功后向遗嘱topic发布的消息,如online"`
+	// SessionExpiry 大于 0 时使用持久会话, 断开期间 broker 保留订阅及未确认的 QoS 1 消息,
+	// 重新连接后继续投递. MQTT 3.1 不支持会话有效期, 由 broker 决定保留时间.
+	SessionExpiry int `json:"sessionExpiry" toml:"sessionExpiry" description:"持久会话保留时间,单位秒,0为不保留会话,需配置固定ClientID"`
+
+	Transport          string            `json:"transport" toml:"transport" description:"传输方式:tcp、ws、wss,默认tcp"`
+	Path               string            `json:"path" toml:"path" description:"WebSocket路径,默认/mqtt"`
+	Headers            map[string]string `json:"headers" toml:"headers" description:"WebSocket握手时附加的HTTP头,如Authorization"`
+	InsecureSkipVerify bool              `json:"insecureSkipVerify" toml:"insecureSkipVerify" description:"wss是否跳过服务端证书校验"`
+
+	ProtocolVersion   int    `json:"protocolVersion" toml:"protocolVersion" description:"MQTT协议版本:3为3.1,4为3.1.1,5为5.0,默认3"`
+	TopicAliasMaximum uint16 `json:"topicAliasMaximum" toml:"topicAliasMaximum" description:"MQTT 5 topic别名最大数量,0为不使用"`
+
+	Routes []TopicRoute `json:"routes" toml:"routes" description:"按消息字段选择topic的路由规则"`
+
+	Codec       string `json:"codec" toml:"codec" description:"消息编码:json、msgpack、cbor、protobuf,默认json"`
+	Compression string `json:"compression" toml:"compression" description:"消息压缩:gzip、zstd,默认不压缩"`
+	TagPayload  bool   `json:"tagPayload" toml:"tagPayload" description:"是否在消息前添加内容类型头"`
+
+	// Recorder 不为空时录制发送和收到的消息.
+	Recorder *Recorder `json:"-" toml:"-"`
+
+	sess *emqttSession
 }
 
-// SendKeyValue is Emqtt 发送key、value.
-func (p *Emqtt) SendKeyValue(key string, val interface{}) (err error) {
-	conn, err := net.Dial("tcp", net.JoinHostPort(p.Host, strconv.Itoa(p.Port)))
+// emqttSession 保存 Connect 建立的长连接.
+type emqttSession struct {
+	mu   sync.Mutex
+	conn emqttClient
+}
+
+const (
+	defaultEmqttKeepAlive = 60
+	defaultEmqttTimeout   = 10
+)
+
+// emqttSessionMu 保护 Emqtt.sess 的创建, 使并发的首次调用共用同一会话.
+var emqttSessionMu sync.Mutex
+
+// session 返回会话, 不存在时创建.
+func (p *Emqtt) session() *emqttSession {
+	emqttSessionMu.Lock()
+	defer emqttSessionMu.Unlock()
+	if p.sess == nil {
+		p.sess = &emqttSession{}
+	}
+	return p.sess
+}
+
+// loadSession 返回会话, 未调用 Connect 时为 nil.
+func (p *Emqtt) loadSession() *emqttSession {
+	emqttSessionMu.Lock()
+	defer emqttSessionMu.Unlock()
+	return p.sess
+}
+
+func (p *Emqtt) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return defaultEmqttTimeout * time.Second
+	}
+	return time.Duration(p.Timeout) * time.Second
+}
+
+func (p *Emqtt) clientID() string {
+	if p.ClientID != "" {
+		return p.ClientID
+	}
+	return fmt.Sprint("cli", rand.Int31())
+}
+
+// connectPacket 根据配置生成 CONNECT 报文.
+func (p *Emqtt) connectPacket() *proto.Connect {
+	keepAlive := p.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = defaultEmqttKeepAlive
+	}
+	req := &proto.Connect{
+		ProtocolName:    "MQIsdp",
+		ProtocolVersion: 3,
+		ClientId:        p.clientID(),
+		CleanSession:    p.SessionExpiry <= 0,
+		KeepAliveTimer:  uint16(keepAlive),
+	}
+	if p.Username != "" {
+		req.UsernameFlag = true
+		req.PasswordFlag = true
+		req.Username = p.Username
+		req.Password = p.Password
+	}
+	if p.WillTopic != "" {
+		req.WillFlag = true
+		req.WillTopic = p.WillTopic
+		req.WillMessage = p.WillPayload
+		req.WillQos = proto.TagQosLevel(p.WillQos)
+		req.WillRetain = p.WillRetain
+	}
+	if p.ProtocolVersion == 4 {
+		req.ProtocolName = "MQTT"
+		req.ProtocolVersion = 4
+	}
+	return req
+}
+
+// validate 校验配置.
+func (p *Emqtt) validate() error {
+	switch p.ProtocolVersion {
+	case 0, 3, 4, 5:
+	default:
+		return fmt.Errorf("不支持的MQTT协议版本%d", p.ProtocolVersion)
+	}
+	if p.WillQos > 1 {
+		return fmt.Errorf("不支持的遗嘱消息QoS等级%d", p.WillQos)
+	}
+	if p.SessionExpiry > 0 && p.ClientID == "" {
+		return errors.New("使用持久会话时需配置固定ClientID")
+	}
+	return nil
+}
+
+// dial 按 ProtocolVersion 建立一个新的 MQTT 连接.
+func (p *Emqtt) dial() (emqttClient, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	conn, err := p.dialConn()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	cc := mqtt.NewClientConn(conn)
-	if err := cc.Connect(p.Username, p.Password); err != nil {
-		return fmt.Errorf("EMQTT连接错误.%v", err)
+	var c emqttClient
+	if p.ProtocolVersion == 5 {
+		c, err = newEmqttV5Conn(conn, p)
+	} else {
+		c, err = newEmqttConn(conn, p.connectPacket(), p.timeout())
 	}
-	m := map[string]interface{}{key: val}
-	mb, err := json.Marshal(m)
+	if err != nil {
+		conn.Close()
+		var rce *ReasonCodeError
+		if errors.As(err, &rce) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("EMQTT连接错误.%v", err)
+	}
+	return c, nil
+}
+
+// Connect is 建立 Emqtt 长连接, 连接期间所有发送复用该连接.
+// 配置 WillTopic 后, 进程崩溃或网络中断时 broker 会向 WillTopic 发布 WillPayload;
+// 配置 BirthPayload 后, 连接成功时会向 WillTopic 发布一条保留的上线消息.
+func (p *Emqtt) Connect() error {
+	sess := p.session()
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.conn != nil && !isClosed(sess.conn) {
+		return nil
+	}
+	c, err := p.dial()
 	if err != nil {
 		return err
 	}
-	cc.Publish(&proto.Publish{
-		TopicName: p.TopicName,
-		Payload:   proto.BytesPayload(mb),
-	})
-	cc.Disconnect()
+	if p.WillTopic != "" && p.BirthPayload != "" {
+		err = c.publish(p.WillTopic, []byte(p.BirthPayload), &publishOptions{qos: p.WillQos, retain: true})
+		if err != nil {
+			c.close()
+			return fmt.Errorf("EMQTT发布上线消息失败.%v", err)
+		}
+	}
+	sess.conn = c
 	return nil
 }
 
-// SendTopicKeyValue is Emqtt 向 topic 发送key、value.
-func (p *Emqtt) SendTopicKeyValue(topic, key string, val interface{}) (err error) {
-	conn, err := net.Dial("tcp", net.JoinHostPort(p.Host, strconv.Itoa(p.Port)))
+// Close is 关闭 Connect 建立的长连接.
+// 正常关闭时 broker 不会发布遗嘱消息, 因此会主动向 WillTopic 发布一条保留的 WillPayload.
+func (p *Emqtt) Close() error {
+	sess := p.loadSession()
+	if sess == nil {
+		return nil
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	c := sess.conn
+	if c == nil {
+		return nil
+	}
+	sess.conn = nil
+	if p.WillTopic != "" && !isClosed(c) {
+		c.publish(p.WillTopic, []byte(p.WillPayload), &publishOptions{qos: p.WillQos, retain: true})
+	}
+	return c.close()
+}
+
+// Publish is Emqtt 向 topic 发送原始数据.
+// topic 可以是包含 {name} 占位符的模板, 配置了 Routes 时按消息字段选择 topic,
+// 此时 payload 需为 JSON 对象.
+// 已调用 Connect 时复用长连接, 连接断开会自动重连; 否则每次发送新建连接.
+func (p *Emqtt) Publish(topic string, payload []byte, opts ...PublishOption) error {
+	return p.publish(topic, payload, func() (map[string]interface{}, error) {
+		m, err := payloadFields(payload)
+		if err != nil {
+			return nil, fmt.Errorf("消息体不是JSON对象,无法按字段选择topic.%v", err)
+		}
+		return m, nil
+	}, newPublishOptions(opts))
+}
+
+func (p *Emqtt) publish(topic string, payload []byte, fields func() (map[string]interface{}, error), o *publishOptions) error {
+	topic, err := resolveTopic(p.Routes, topic, o.topicVars, fields)
 	if err != nil {
 		return err
 	}
-	cc := mqtt.NewClientConn(conn)
-	if err := cc.Connect(p.Username, p.Password); err != nil {
-		return fmt.Errorf("EMQTT连接错误.%v", err)
-	}
-	m := map[string]interface{}{key: val}
-	mb, err := json.Marshal(m)
+	c, release, err := p.acquire()
 	if err != nil {
 		return err
 	}
-	cc.Publish(&proto.Publish{
-		TopicName: p.TopicName,
-		Payload:   proto.BytesPayload(mb),
-	})
-	cc.Disconnect()
+	defer release()
+	if err := c.publish(topic, payload, o); err != nil {
+		return err
+	}
+	if p.Recorder != nil {
+		p.Recorder.Record(Record{
+			Broker:    "emqtt",
+			Direction: DIRECTION_PUBLISH,
+			Topic:     topic,
+			Payload:   payload,
+			Qos:       o.qos,
+			Retain:    o.retain,
+		})
+	}
 	return nil
 }
 
-// SendTopicValue is Emqtt 发送topic、value.
-func (p *Emqtt) SendTopicValue(topic string, val interface{}) (err error) {
-	conn, err := net.Dial("tcp", net.JoinHostPort(p.Host, strconv.Itoa(p.Port)))
+// acquire 返回可用的连接, 已调用 Connect 时返回长连接并在断开时自动重连,
+// 否则新建连接, release 用于释放新建的连接.
+func (p *Emqtt) acquire() (c emqttClient, release func(), err error) {
+	if sess := p.loadSession(); sess != nil {
+		sess.mu.Lock()
+		c = sess.conn
+		sess.mu.Unlock()
+		if c != nil && isClosed(c) {
+			if err := p.Connect(); err != nil {
+				return nil, nil, err
+			}
+			sess.mu.Lock()
+			c = sess.conn
+			sess.mu.Unlock()
+		}
+		if c != nil {
+			return c, func() {}, nil
+		}
+	}
+	c, err = p.dial()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	cc := mqtt.NewClientConn(conn)
-	if err := cc.Connect(p.Username, p.Password); err != nil {
-		return fmt.Errorf("EMQTT连接错误.%v", err)
+	return c, func() { c.close() }, nil
+}
+
+// Subscribe is 订阅 topic 并使用 h 处理收到的消息, 阻塞直到 ctx 结束或连接断开.
+// 默认同一订阅的消息按顺序处理, 可通过 WithWorkers、WithOrderKey 并发处理,
+// 通过 WithShareGroup 在多个实例间分摊消息.
+// QoS 1 的消息在 h 返回后确认, 处理失败的消息同样会被确认, 指定 WithNoAckOnError 时除外.
+// MQTT 3.1/3.1.1 只支持 QoS 0 和 1, WithSubscribeQos 大于 1 时返回错误.
+// 已调用 Connect 时复用长连接, 否则为该订阅新建连接, 此时配置了固定 ClientID
+// 的多个订阅会互相踢下线, 应先调用 Connect.
+func (p *Emqtt) Subscribe(ctx context.Context, topic string, h Handler, opts ...SubscribeOption) error {
+	o := newSubscribeOptions(opts)
+	if o.qos > 1 && p.ProtocolVersion != 5 {
+		return fmt.Errorf("MQTT 3.1不支持订阅QoS等级%d", o.qos)
 	}
-	mb, err := json.Marshal(val)
+	c, release, err := p.acquire()
 	if err != nil {
 		return err
 	}
-	cc.Publish(&proto.Publish{
-		TopicName: topic,
-		Payload:   proto.BytesPayload(mb),
-	})
-	cc.Disconnect()
-	return nil
+	defer release()
+	filter := o.filter(topic)
+	sub, err := c.subscribe(filter, o)
+	if err != nil {
+		var rce *ReasonCodeError
+		if errors.As(err, &rce) {
+			return err
+		}
+		return fmt.Errorf("EMQTT订阅失败.%v", err)
+	}
+	defer c.unsubscribe(filter)
+	h = Chain(h, o.middlewares...)
+	if p.Recorder != nil {
+		h = p.Recorder.Handler("emqtt", h)
+	}
+	return consume(ctx, c, sub, h, o)
 }
 
-// Send is Emqtt 发送 msg 数据.
-func (p *Emqtt) Send(msg string) (err error) {
-	conn, err := net.Dial("tcp", net.JoinHostPort(p.Host, strconv.Itoa(p.Port)))
+// payloadBytes 取出消息内容.
+func payloadBytes(payload proto.Payload) []byte {
+	if b, ok := payload.(proto.BytesPayload); ok {
+		return b
+	}
+	var buf bytes.Buffer
+	payload.WritePayload(&buf)
+	return buf.Bytes()
+}
+
+// SendKeyValue is Emqtt 发送key、value.
+func (p *Emqtt) SendKeyValue(key string, val interface{}, opts ...PublishOption) (err error) {
+	return p.SendTopicKeyValue(p.TopicName, key, val, opts...)
+}
+
+// SendTopicKeyValue is Emqtt 向 topic 发送key、value.
+func (p *Emqtt) SendTopicKeyValue(topic, key string, val interface{}, opts ...PublishOption) (err error) {
+	return p.SendTopicValue(topic, map[string]interface{}{key: val}, opts...)
+}
+
+// SendTopicValue is Emqtt 发送topic、value.
+// val 按 Codec 编码、Compression 压缩, TagPayload 为 true 时添加内容类型头,
+// 消费者可使用 DecodeTaggedPayload 自动解码.
+// MQTT 5 下内容类型通过消息属性发送, 压缩方式通过 content-encoding 消息头发送.
+// topic 模板未通过 WithTopicVars 指定变量时使用 val 的字段填充.
+func (p *Emqtt) SendTopicValue(topic string, val interface{}, opts ...PublishOption) (err error) {
+	codec, err := GetCodec(p.Codec)
+	if err != nil {
+		return err
+	}
+	mb, err := EncodePayload(codec, p.Compression, val)
 	if err != nil {
 		return err
 	}
-	cc := mqtt.NewClientConn(conn)
-	if err := cc.Connect(p.Username, p.Password); err != nil {
-		return fmt.Errorf("EMQTT连接错误.%v", err)
+	o := newPublishOptions(opts)
+	if p.ProtocolVersion == 5 {
+		o.contentType = codec.ContentType()
+		if p.Compression != "" {
+			WithHeader(HeaderContentEncoding, p.Compression)(o)
+		}
+	} else if p.TagPayload {
+		if mb, err = TagPayload(codec.ContentType(), p.Compression, mb); err != nil {
+			return err
+		}
 	}
-	cc.Publish(&proto.Publish{
-		TopicName: p.TopicName,
-		Payload:   proto.BytesPayload([]byte(msg)),
-	})
-	cc.Disconnect()
-	return nil
+	return p.publish(topic, mb, func() (map[string]interface{}, error) {
+		return topicFields(val)
+	}, o)
+}
+
+// Send is Emqtt 发送 msg 数据.
+func (p *Emqtt) Send(msg string, opts ...PublishOption) (err error) {
+	return p.Publish(p.TopicName, []byte(msg), opts...)
 }
@@ -0,0 +1,441 @@
+package mq
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	proto "github.com/huin/mqtt"
+)
+
+// errConnClosed 连接已关闭.
+var errConnClosed = errors.New("EMQTT连接已关闭")
+
+// notifier 为可以通知断开的连接.
+type notifier interface {
+	// done 返回连接断开时关闭的通道.
+	done() <-chan struct{}
+	// closeErr 返回导致连接断开的错误.
+	closeErr() error
+}
+
+// emqttClient 为不同 MQTT 协议版本的连接实现.
+type emqttClient interface {
+	notifier
+	publish(topic string, payload []byte, o *publishOptions) error
+	subscribe(filter string, o *subscribeOptions) (*emqttSub, error)
+	unsubscribe(filter string) error
+	close() error
+}
+
+// isClosed returns true if 连接已断开.
+func isClosed(c notifier) bool {
+	select {
+	case <-c.done():
+		return true
+	default:
+		return false
+	}
+}
+
+// emqttSub 为一个订阅, 收到的消息按顺序写入 ch.
+// 连接的读取协程通过 push 将消息放入无界队列, 不会因订阅处理缓慢而阻塞,
+// 否则处理函数在同一连接上等待的 PUBACK 等确认报文将无法读取.
+type emqttSub struct {
+	ch   chan *inbound
+	done chan struct{}
+
+	mu     sync.Mutex
+	queue  []*inbound
+	closed bool
+	notify chan struct{}
+}
+
+// newEmqttSub 创建订阅并启动将队列写入 ch 的协程, 订阅取消时关闭 done.
+func newEmqttSub() *emqttSub {
+	s := &emqttSub{
+		ch:     make(chan *inbound),
+		done:   make(chan struct{}),
+		notify: make(chan struct{}, 1),
+	}
+	go s.pump()
+	return s
+}
+
+// push 将消息放入队列, 订阅已取消时直接确认.
+func (s *emqttSub) push(in *inbound) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		in.ack()
+		return
+	}
+	s.queue = append(s.queue, in)
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pump 按顺序将队列中的消息写入 ch, 订阅取消后确认剩余的消息.
+func (s *emqttSub) pump() {
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.notify:
+				continue
+			case <-s.done:
+				s.drain()
+				return
+			}
+		}
+		in := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+		select {
+		case s.ch <- in:
+		case <-s.done:
+			in.ack()
+			s.drain()
+			return
+		}
+	}
+}
+
+// drain 确认队列中剩余的消息, 之后 push 的消息直接确认.
+func (s *emqttSub) drain() {
+	s.mu.Lock()
+	queue := s.queue
+	s.queue = nil
+	s.closed = true
+	s.mu.Unlock()
+	for _, in := range queue {
+		in.ack()
+	}
+}
+
+// inbound 为收到的一条消息, 所有匹配的订阅处理完成后才确认.
+// nackFn 不为空时处理失败的消息调用 nackFn 拒绝, 由 broker 重新投递.
+type inbound struct {
+	msg    *Message
+	refs   int32
+	ackFn  func()
+	nackFn func()
+}
+
+// ack 在消息的所有订阅处理完成后确认消息.
+func (in *inbound) ack() {
+	if atomic.AddInt32(&in.refs, -1) == 0 && in.ackFn != nil {
+		in.ackFn()
+	}
+}
+
+// subRouter 按订阅过滤器分发收到的消息.
+type subRouter struct {
+	mu   sync.Mutex
+	subs map[string]*emqttSub
+}
+
+// add 添加订阅, 同一连接上每个 filter 只能订阅一次.
+func (r *subRouter) add(filter string) (*emqttSub, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subs == nil {
+		r.subs = make(map[string]*emqttSub)
+	}
+	if _, ok := r.subs[filter]; ok {
+		return nil, fmt.Errorf("topic %s 已订阅", filter)
+	}
+	sub := newEmqttSub()
+	r.subs[filter] = sub
+	return sub, nil
+}
+
+func (r *subRouter) remove(filter string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sub, ok := r.subs[filter]; ok {
+		close(sub.done)
+		delete(r.subs, filter)
+	}
+}
+
+// dispatch 将消息分发给所有匹配的订阅, 没有匹配的订阅时直接确认, 不会阻塞.
+func (r *subRouter) dispatch(msg *Message, ackFn func()) {
+	r.mu.Lock()
+	var subs []*emqttSub
+	for filter, sub := range r.subs {
+		if MatchTopic(shareTopic(filter), msg.Topic) {
+			subs = append(subs, sub)
+		}
+	}
+	r.mu.Unlock()
+
+	in := &inbound{msg: msg, refs: int32(len(subs)), ackFn: ackFn}
+	if len(subs) == 0 {
+		in.refs = 1
+		in.ack()
+		return
+	}
+	for _, sub := range subs {
+		sub.push(in)
+	}
+}
+
+// emqttConn 为基于 huin/mqtt 编解码实现的 MQTT 3.1/3.1.1 长连接,
+// 负责连接握手、心跳、QoS 1 确认、订阅分发以及连接断开检测.
+type emqttConn struct {
+	conn    net.Conn
+	timeout time.Duration
+	router  subRouter
+
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	msgID   uint16
+	pending map[uint16]chan proto.Message
+	err     error
+
+	closed chan struct{}
+}
+
+// newEmqttConn 在 conn 上完成 CONNECT 握手并启动读取协程.
+func newEmqttConn(conn net.Conn, connect *proto.Connect, timeout time.Duration) (*emqttConn, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := connect.Encode(conn); err != nil {
+		return nil, err
+	}
+	msg, err := proto.DecodeOneMessage(conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	ack, ok := msg.(*proto.ConnAck)
+	if !ok {
+		return nil, fmt.Errorf("期望收到CONNACK,实际收到%T", msg)
+	}
+	if ack.ReturnCode != proto.RetCodeAccepted {
+		return nil, fmt.Errorf("连接被拒绝,返回码%d", ack.ReturnCode)
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &emqttConn{
+		conn:    conn,
+		timeout: timeout,
+		pending: make(map[uint16]chan proto.Message),
+		closed:  make(chan struct{}),
+	}
+	go c.readLoop()
+	if connect.KeepAliveTimer > 0 {
+		go c.pingLoop(time.Duration(connect.KeepAliveTimer) * time.Second / 2)
+	}
+	return c, nil
+}
+
+// write 串行写入一条报文.
+func (c *emqttConn) write(m proto.Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return m.Encode(c.conn)
+}
+
+// request 分配报文标识, 发送 build 生成的报文并等待对应的确认报文.
+func (c *emqttConn) request(build func(id uint16) proto.Message) (proto.Message, error) {
+	ack := make(chan proto.Message, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	id := c.nextID()
+	c.pending[id] = ack
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	m := build(id)
+	if err := c.write(m); err != nil {
+		return nil, err
+	}
+	select {
+	case r := <-ack:
+		return r, nil
+	case <-c.closed:
+		return nil, c.closeErr()
+	case <-time.After(c.timeout):
+		return nil, fmt.Errorf("等待%T确认超时", m)
+	}
+}
+
+// publish 发送消息, QoS 1 时等待 broker 的 PUBACK.
+// MQTT 3.1 不支持消息属性, WithHeader、WithExpiry 等选项被忽略.
+func (c *emqttConn) publish(topic string, payload []byte, o *publishOptions) error {
+	m := &proto.Publish{
+		Header:    proto.Header{QosLevel: proto.TagQosLevel(o.qos), Retain: o.retain},
+		TopicName: topic,
+		Payload:   proto.BytesPayload(payload),
+	}
+	switch m.QosLevel {
+	case proto.QosAtMostOnce:
+		return c.write(m)
+	case proto.QosAtLeastOnce:
+	default:
+		return fmt.Errorf("不支持的QoS等级%d", m.QosLevel)
+	}
+	_, err := c.request(func(id uint16) proto.Message {
+		m.MessageId = id
+		return m
+	})
+	return err
+}
+
+// subscribe 订阅 filter.
+func (c *emqttConn) subscribe(filter string, o *subscribeOptions) (*emqttSub, error) {
+	sub, err := c.router.add(filter)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.request(func(id uint16) proto.Message {
+		return &proto.Subscribe{
+			Header:    proto.Header{QosLevel: proto.QosAtLeastOnce},
+			MessageId: id,
+			Topics:    []proto.TopicQos{{Topic: filter, Qos: proto.TagQosLevel(o.qos)}},
+		}
+	})
+	if err == nil {
+		if ack, ok := r.(*proto.SubAck); !ok || len(ack.TopicsQos) == 0 || ack.TopicsQos[0] == 0x80 {
+			err = fmt.Errorf("订阅topic %s 被拒绝", filter)
+		}
+	}
+	if err != nil {
+		c.router.remove(filter)
+		return nil, err
+	}
+	return sub, nil
+}
+
+// unsubscribe 取消订阅 filter.
+func (c *emqttConn) unsubscribe(filter string) error {
+	c.router.remove(filter)
+	if isClosed(c) {
+		return nil
+	}
+	_, err := c.request(func(id uint16) proto.Message {
+		return &proto.Unsubscribe{
+			Header:    proto.Header{QosLevel: proto.QosAtLeastOnce},
+			MessageId: id,
+			Topics:    []string{filter},
+		}
+	})
+	return err
+}
+
+// nextID 生成非零的报文标识, 调用方需持有 c.mu.
+func (c *emqttConn) nextID() uint16 {
+	for {
+		c.msgID++
+		if _, ok := c.pending[c.msgID]; c.msgID != 0 && !ok {
+			return c.msgID
+		}
+	}
+}
+
+func (c *emqttConn) readLoop() {
+	for {
+		msg, err := proto.DecodeOneMessage(c.conn, nil)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch m := msg.(type) {
+		case *proto.PubAck:
+			c.resolve(m.MessageId, m)
+		case *proto.SubAck:
+			c.resolve(m.MessageId, m)
+		case *proto.UnsubAck:
+			c.resolve(m.MessageId, m)
+		case *proto.Publish:
+			var ackFn func()
+			if m.QosLevel == proto.QosAtLeastOnce {
+				id := m.MessageId
+				ackFn = func() { c.write(&proto.PubAck{MessageId: id}) }
+			}
+			c.router.dispatch(&Message{
+				Topic:   m.TopicName,
+				Payload: payloadBytes(m.Payload),
+				Qos:     byte(m.QosLevel),
+				Retain:  m.Retain,
+			}, ackFn)
+		}
+	}
+}
+
+func (c *emqttConn) resolve(id uint16, m proto.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ack, ok := c.pending[id]; ok {
+		ack <- m
+		delete(c.pending, id)
+	}
+}
+
+func (c *emqttConn) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			if err := c.write(&proto.PingReq{}); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// fail 记录连接错误并唤醒所有等待者.
+func (c *emqttConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = fmt.Errorf("EMQTT连接断开.%v", err)
+	close(c.closed)
+	c.conn.Close()
+}
+
+// done 返回连接断开时关闭的通道.
+func (c *emqttConn) done() <-chan struct{} {
+	return c.closed
+}
+
+// closeErr 返回导致连接断开的错误.
+func (c *emqttConn) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// close 发送 DISCONNECT 后关闭连接, 正常断开时 broker 不会发布遗嘱消息.
+func (c *emqttConn) close() error {
+	if isClosed(c) {
+		return nil
+	}
+	err := c.write(&proto.Disconnect{})
+	c.fail(errConnClosed)
+	return err
+}
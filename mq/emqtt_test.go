@@ -0,0 +1,115 @@
+package mq_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhgqiang/commongo/mq"
+	"github.com/zhgqiang/commongo/mq/mqtest"
+)
+
+func TestEmqtt_Connect(t *testing.T) {
+	b, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	p := b.Config()
+	p.WillTopic = "status/dev1"
+	p.WillPayload = "offline"
+	p.BirthPayload = "online"
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Connect(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	defer p.Close()
+	if _, err := b.WaitMessages(p.WillTopic, 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := len(b.Messages()); n != 1 {
+		t.Fatalf("并发 Connect 应只建立一个连接, 收到 %d 条上线消息", n)
+	}
+
+	q := b.Config()
+	q.WillTopic = "status/dev2"
+	q.WillQos = 2
+	if err := q.Connect(); err == nil {
+		q.Close()
+		t.Fatal("WillQos 为 2 时应返回错误")
+	}
+	q = b.Config()
+	q.SessionExpiry = 60
+	if err := q.Connect(); err == nil {
+		q.Close()
+		t.Fatal("持久会话未配置 ClientID 时应返回错误")
+	}
+	q = b.Config()
+	if err := q.Subscribe(context.Background(), "a", nil, mq.WithSubscribeQos(2)); err == nil {
+		t.Fatal("MQTT 3.1 订阅 QoS 2 时应返回错误")
+	}
+}
+
+func TestEmqtt_ShareGroup(t *testing.T) {
+	const n = 10
+	b, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	for _, id := range []string{"worker1", "worker2"} {
+		p := b.Config()
+		p.ClientID = id
+		if err := p.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+		id := id
+		go p.Subscribe(ctx, "jobs/#", func(ctx context.Context, msg *mq.Message) error {
+			mu.Lock()
+			counts[id]++
+			mu.Unlock()
+			return nil
+		}, mq.WithShareGroup("g1"), mq.WithSubscribeQos(1))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	pub := b.Config()
+	for i := 0; i < n; i++ {
+		if err := pub.Publish("jobs/j1", []byte("x"), mq.WithQos(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		total := counts["worker1"] + counts["worker2"]
+		c1, c2 := counts["worker1"], counts["worker2"]
+		mu.Unlock()
+		if total == n {
+			if c1 != n/2 || c2 != n/2 {
+				t.Fatalf("共享订阅应平均分摊消息, 实际 %d/%d", c1, c2)
+			}
+			break
+		}
+		if total > n || time.Now().After(deadline) {
+			t.Fatalf("共享订阅共收到 %d 条消息, 期望 %d 条", total, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
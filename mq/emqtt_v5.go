@@ -0,0 +1,240 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/eclipse/paho.golang/paho/extensions/topicaliases"
+)
+
+// emqttV5Conn 为基于 paho.golang 实现的 MQTT 5 长连接.
+type emqttV5Conn struct {
+	client  *paho.Client
+	timeout time.Duration
+	router  subRouter
+	aliases *topicaliases.TAHandler
+
+	mu  sync.Mutex
+	err error
+}
+
+// newEmqttV5Conn 在 conn 上完成 MQTT 5 连接握手.
+func newEmqttV5Conn(conn net.Conn, p *Emqtt) (*emqttV5Conn, error) {
+	c := &emqttV5Conn{timeout: p.timeout()}
+	c.client = paho.NewClient(paho.ClientConfig{
+		ClientID:                   p.clientID(),
+		Conn:                       conn,
+		PacketTimeout:              c.timeout,
+		EnableManualAcknowledgment: true,
+		OnPublishReceived:          []func(paho.PublishReceived) (bool, error){c.onPublish},
+		OnClientError:              c.fail,
+		OnServerDisconnect: func(d *paho.Disconnect) {
+			var reason string
+			if d.Properties != nil {
+				reason = d.Properties.ReasonString
+			}
+			c.fail(&ReasonCodeError{Op: "disconnect", Code: ReasonCode(d.ReasonCode), Reason: reason})
+		},
+		PublishHook: func(pb *paho.Publish) {
+			if c.aliases != nil {
+				c.aliases.PublishHook(pb)
+			}
+		},
+	})
+
+	keepAlive := p.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = defaultEmqttKeepAlive
+	}
+	cp := &paho.Connect{
+		ClientID:   c.client.ClientID(),
+		KeepAlive:  uint16(keepAlive),
+		CleanStart: p.SessionExpiry <= 0,
+	}
+	if p.SessionExpiry > 0 {
+		expiry := uint32(p.SessionExpiry)
+		cp.Properties = &paho.ConnectProperties{SessionExpiryInterval: &expiry}
+	}
+	if p.Username != "" {
+		cp.UsernameFlag = true
+		cp.PasswordFlag = true
+		cp.Username = p.Username
+		cp.Password = []byte(p.Password)
+	}
+	if p.WillTopic != "" {
+		cp.WillMessage = &paho.WillMessage{
+			Retain:  p.WillRetain,
+			QoS:     p.WillQos,
+			Topic:   p.WillTopic,
+			Payload: []byte(p.WillPayload),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	ca, err := c.client.Connect(ctx, cp)
+	if err != nil {
+		if ca != nil && ca.ReasonCode >= 0x80 {
+			return nil, &ReasonCodeError{Op: "connect", Code: ReasonCode(ca.ReasonCode), Reason: connackReason(ca)}
+		}
+		return nil, err
+	}
+	// 双方都支持时启用 topic 别名, 减少重复发送长 topic 的流量
+	if p.TopicAliasMaximum > 0 && ca.Properties != nil && ca.Properties.TopicAliasMaximum != nil {
+		max := p.TopicAliasMaximum
+		if *ca.Properties.TopicAliasMaximum < max {
+			max = *ca.Properties.TopicAliasMaximum
+		}
+		if max > 0 {
+			c.aliases = topicaliases.NewTAHandler(max)
+		}
+	}
+	return c, nil
+}
+
+func connackReason(ca *paho.Connack) string {
+	if ca.Properties == nil {
+		return ""
+	}
+	return ca.Properties.ReasonString
+}
+
+func (c *emqttV5Conn) publish(topic string, payload []byte, o *publishOptions) error {
+	pb := &paho.Publish{
+		QoS:     o.qos,
+		Retain:  o.retain,
+		Topic:   topic,
+		Payload: payload,
+	}
+	props := &paho.PublishProperties{}
+	for k, v := range o.messageHeaders() {
+		props.User.Add(k, v)
+	}
+	if o.expiry > 0 {
+		expiry := uint32(o.expiry / time.Second)
+		if expiry == 0 {
+			expiry = 1
+		}
+		props.MessageExpiry = &expiry
+	}
+	props.ContentType = o.contentType
+	props.ResponseTopic = o.replyTo
+	if o.correlationID != "" {
+		props.CorrelationData = []byte(o.correlationID)
+	}
+	pb.Properties = props
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	pr, err := c.client.Publish(ctx, pb)
+	if pr != nil && pr.ReasonCode >= 0x80 {
+		var reason string
+		if pr.Properties != nil {
+			reason = pr.Properties.ReasonString
+		}
+		return &ReasonCodeError{Op: "publish", Code: ReasonCode(pr.ReasonCode), Reason: reason}
+	}
+	return err
+}
+
+func (c *emqttV5Conn) subscribe(filter string, o *subscribeOptions) (*emqttSub, error) {
+	sub, err := c.router.add(filter)
+	if err != nil {
+		return nil, err
+	}
+	s := &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: filter, QoS: o.qos}},
+	}
+	if len(o.headers) > 0 {
+		s.Properties = &paho.SubscribeProperties{}
+		for k, v := range o.headers {
+			s.Properties.User.Add(k, v)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	sa, err := c.client.Subscribe(ctx, s)
+	if sa != nil && len(sa.Reasons) > 0 && sa.Reasons[0] >= 0x80 {
+		var reason string
+		if sa.Properties != nil {
+			reason = sa.Properties.ReasonString
+		}
+		err = &ReasonCodeError{Op: "subscribe", Code: ReasonCode(sa.Reasons[0]), Reason: reason}
+	}
+	if err != nil {
+		c.router.remove(filter)
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (c *emqttV5Conn) unsubscribe(filter string) error {
+	c.router.remove(filter)
+	if isClosed(c) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	_, err := c.client.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{filter}})
+	return err
+}
+
+// onPublish 将收到的消息分发给订阅, 所有订阅处理完成后确认.
+func (c *emqttV5Conn) onPublish(pr paho.PublishReceived) (bool, error) {
+	pb := pr.Packet
+	msg := &Message{
+		Topic:   pb.Topic,
+		Payload: pb.Payload,
+		Qos:     pb.QoS,
+		Retain:  pb.Retain,
+	}
+	if props := pb.Properties; props != nil {
+		if len(props.User) > 0 {
+			msg.Headers = make(map[string]string, len(props.User))
+			for _, u := range props.User {
+				msg.Headers[u.Key] = u.Value
+			}
+		}
+		msg.ContentType = props.ContentType
+		msg.ResponseTopic = props.ResponseTopic
+		msg.CorrelationID = string(props.CorrelationData)
+		if props.MessageExpiry != nil {
+			msg.Expiry = time.Duration(*props.MessageExpiry) * time.Second
+		}
+	}
+	c.router.dispatch(msg, func() { c.client.Ack(pb) })
+	return true, nil
+}
+
+func (c *emqttV5Conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = fmt.Errorf("EMQTT连接断开.%v", err)
+	}
+}
+
+func (c *emqttV5Conn) done() <-chan struct{} {
+	return c.client.Done()
+}
+
+func (c *emqttV5Conn) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		return errConnClosed
+	}
+	return c.err
+}
+
+func (c *emqttV5Conn) close() error {
+	if isClosed(c) {
+		return nil
+	}
+	c.fail(errConnClosed)
+	return c.client.Disconnect(&paho.Disconnect{ReasonCode: byte(REASON_SUCCESS)})
+}
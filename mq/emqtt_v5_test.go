@@ -0,0 +1,138 @@
+package mq_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/zhgqiang/commongo/mq"
+)
+
+// denyHook 允许所有连接, 拒绝发布和订阅 deny/ 开头的 topic.
+type denyHook struct {
+	mqtt.HookBase
+}
+
+func (h *denyHook) ID() string { return "deny" }
+
+func (h *denyHook) Provides(b byte) bool {
+	return b == mqtt.OnConnectAuthenticate || b == mqtt.OnACLCheck
+}
+
+func (h *denyHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
+	return true
+}
+
+func (h *denyHook) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
+	return !strings.HasPrefix(topic, "deny/")
+}
+
+// newV5Broker 启动支持 MQTT 5 的 broker, 返回连接该 broker 的配置.
+func newV5Broker(t *testing.T) mq.Emqtt {
+	server := mqtt.New(&mqtt.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := server.AddHook(new(denyHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: "127.0.0.1:0"})
+	if err := server.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+	host, port, _ := net.SplitHostPort(tcp.Address())
+	p, _ := strconv.Atoi(port)
+	return mq.Emqtt{Host: host, Port: p, Timeout: 5, ProtocolVersion: 5}
+}
+
+func TestEmqtt_V5(t *testing.T) {
+	cfg := newV5Broker(t)
+
+	sub := cfg
+	sub.ClientID = "sub"
+	if err := sub.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	var mu sync.Mutex
+	var got []*mq.Message
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sub.Subscribe(ctx, "v5/#", func(ctx context.Context, msg *mq.Message) error {
+		mu.Lock()
+		got = append(got, msg)
+		mu.Unlock()
+		return nil
+	}, mq.WithSubscribeQos(1))
+	time.Sleep(100 * time.Millisecond)
+
+	pub := cfg
+	pub.ClientID = "pub"
+	pub.TopicAliasMaximum = 10
+	if err := pub.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	// 第二条消息使用 topic 别名发送
+	for i := 0; i < 2; i++ {
+		err := pub.Publish("v5/dev1/telemetry", []byte("hello"),
+			mq.WithQos(1),
+			mq.WithHeader("tenant", "t1"),
+			mq.WithMessageID("m"+strconv.Itoa(i)),
+			mq.WithExpiry(time.Minute),
+			mq.WithContentType("text/plain"),
+			mq.WithReplyTo("v5/reply"),
+			mq.WithCorrelationID("c1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("收到 %d 条消息, 期望 2 条", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i, msg := range got {
+		if msg.Topic != "v5/dev1/telemetry" || !bytes.Equal(msg.Payload, []byte("hello")) {
+			t.Fatalf("消息 %d: topic %q, payload %q", i, msg.Topic, msg.Payload)
+		}
+		if msg.Headers["tenant"] != "t1" || msg.Headers[mq.HeaderMessageID] != "m"+strconv.Itoa(i) {
+			t.Fatalf("消息 %d: 消息头 %v", i, msg.Headers)
+		}
+		if msg.ContentType != "text/plain" || msg.ResponseTopic != "v5/reply" || msg.CorrelationID != "c1" {
+			t.Fatalf("消息 %d: 属性 %+v", i, msg)
+		}
+		if msg.Expiry <= 0 || msg.Expiry > time.Minute {
+			t.Fatalf("消息 %d: 有效期 %v", i, msg.Expiry)
+		}
+	}
+
+	var rce *mq.ReasonCodeError
+	err := pub.Publish("deny/x", []byte("x"), mq.WithQos(1))
+	if !errors.As(err, &rce) || rce.Op != "publish" || rce.Code != mq.REASON_NOT_AUTHORIZED {
+		t.Fatalf("发布被拒绝时应返回原因码错误, 实际 %v", err)
+	}
+	err = pub.Subscribe(ctx, "deny/#", func(context.Context, *mq.Message) error { return nil })
+	if !errors.As(err, &rce) || rce.Op != "subscribe" || !rce.Code.IsError() {
+		t.Fatalf("订阅被拒绝时应返回原因码错误, 实际 %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package mq
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// TRANSPORT_TCP is 通过 TCP 连接 broker.
+	TRANSPORT_TCP = "tcp"
+	// TRANSPORT_WS is 通过 WebSocket 连接 broker.
+	TRANSPORT_WS = "ws"
+	// TRANSPORT_WSS is 通过 TLS 加密的 WebSocket 连接 broker.
+	TRANSPORT_WSS = "wss"
+)
+
+// defaultEmqttWSPath 为 WebSocket 默认路径.
+const defaultEmqttWSPath = "/mqtt"
+
+// dialConn 按 Transport 建立底层连接.
+func (p *Emqtt) dialConn() (net.Conn, error) {
+	addr := net.JoinHostPort(p.Host, strconv.Itoa(p.Port))
+	switch p.Transport {
+	case "", TRANSPORT_TCP:
+		return net.DialTimeout("tcp", addr, p.timeout())
+	case TRANSPORT_WS, TRANSPORT_WSS:
+		return p.dialWebSocket(addr)
+	default:
+		return nil, fmt.Errorf("不支持的MQTT传输方式%s", p.Transport)
+	}
+}
+
+// dialWebSocket 建立 WebSocket 连接, 子协议为 mqtt, MQTT 3.1 同时提供 mqttv3.1.
+func (p *Emqtt) dialWebSocket(addr string) (net.Conn, error) {
+	path := p.Path
+	if path == "" {
+		path = defaultEmqttWSPath
+	} else if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u := url.URL{Scheme: p.Transport, Host: addr, Path: path}
+	d := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: p.timeout(),
+		Subprotocols:     []string{"mqtt"},
+	}
+	if p.ProtocolVersion == 0 || p.ProtocolVersion == 3 {
+		d.Subprotocols = append(d.Subprotocols, "mqttv3.1")
+	}
+	if p.Transport == TRANSPORT_WSS {
+		d.TLSClientConfig = &tls.Config{ServerName: p.Host, InsecureSkipVerify: p.InsecureSkipVerify}
+	}
+	header := http.Header{}
+	for k, v := range p.Headers {
+		header.Set(k, v)
+	}
+	ws, resp, err := d.Dial(u.String(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("WebSocket握手失败,状态码%d.%v", resp.StatusCode, err)
+		}
+		return nil, err
+	}
+	return &wsConn{ws: ws}, nil
+}
+
+// wsConn 将 WebSocket 连接适配为 net.Conn, 写入的数据作为二进制消息发送,
+// 读取时将连续的消息视为字节流, MQTT 报文可以跨消息.
+type wsConn struct {
+	ws  *websocket.Conn
+	r   io.Reader
+	wmu sync.Mutex
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for {
+		if c.r == nil {
+			typ, r, err := c.ws.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if typ != websocket.BinaryMessage {
+				continue
+			}
+			c.r = r
+		}
+		n, err := c.r.Read(b)
+		if err == io.EOF {
+			c.r = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) Close() error {
+	c.wmu.Lock()
+	c.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.wmu.Unlock()
+	return c.ws.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
@@ -0,0 +1,66 @@
+package mq
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestEmqtt_dialWebSocket(t *testing.T) {
+	received := make(chan string, 1)
+	upgrader := websocket.Upgrader{Subprotocols: []string{"mqtt"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws" || r.Header.Get("Authorization") != "Bearer t" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		// 一个报文拆分到两个消息中发送
+		ws.WriteMessage(websocket.BinaryMessage, []byte{0x20, 0x02})
+		ws.WriteMessage(websocket.BinaryMessage, []byte{0x00, 0x00})
+		_, b, err := ws.ReadMessage()
+		if err == nil {
+			received <- string(b)
+		}
+	}))
+	defer srv.Close()
+
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p := &Emqtt{Host: host, Transport: TRANSPORT_WS, Path: "ws", Headers: map[string]string{"Authorization": "Bearer t"}}
+	p.Port, _ = strconv.Atoi(port)
+	conn, err := p.dialConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ws := conn.(*wsConn).ws; ws.Subprotocol() != "mqtt" {
+		t.Fatalf("子协议 %q, 期望 mqtt", ws.Subprotocol())
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "\x20\x02\x00\x00" {
+		t.Fatalf("读取 %x", buf)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "ping" {
+		t.Fatalf("服务端收到 %q", got)
+	}
+
+	p.Headers = nil
+	if _, err := p.dialConn(); err == nil {
+		t.Fatal("握手被拒绝时应返回错误")
+	}
+}
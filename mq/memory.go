@@ -0,0 +1,165 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var errMemoryClosed = errors.New("内存broker已关闭")
+
+// MemoryBroker is 进程内的 broker, 实现 Publisher 和 Subscriber, 用于单元测试或单进程内解耦.
+// topic 语义与 MQTT 相同, 支持通配符、保留消息和 WithShareGroup 共享订阅,
+// 消息属性 WithHeader、WithContentType 等原样传给订阅者.
+type MemoryBroker struct {
+	mu       sync.Mutex
+	subs     []*memorySub
+	retained map[string]*Message
+	next     map[string]int // 共享订阅轮询位置
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// memorySub 为一个订阅.
+type memorySub struct {
+	filter string
+	group  string
+	sub    *emqttSub
+}
+
+// NewMemoryBroker is 创建内存 broker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		retained: make(map[string]*Message),
+		next:     make(map[string]int),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Publish is 将消息投递给所有匹配的订阅, 共享订阅的每个分组轮询投递给其中一个订阅者.
+// 订阅者处理不过来时阻塞.
+func (b *MemoryBroker) Publish(topic string, payload []byte, opts ...PublishOption) error {
+	o := newPublishOptions(opts)
+	msg := &Message{
+		Topic:         topic,
+		Payload:       payload,
+		Qos:           o.qos,
+		Retain:        o.retain,
+		Headers:       o.messageHeaders(),
+		ContentType:   o.contentType,
+		ResponseTopic: o.replyTo,
+		CorrelationID: o.correlationID,
+		Expiry:        o.expiry,
+	}
+
+	b.mu.Lock()
+	if isClosed(b) {
+		b.mu.Unlock()
+		return errMemoryClosed
+	}
+	if o.retain {
+		if len(payload) == 0 {
+			delete(b.retained, topic)
+		} else {
+			b.retained[topic] = msg
+		}
+	}
+	var targets []*emqttSub
+	groups := make(map[string][]*emqttSub)
+	var keys []string
+	for _, s := range b.subs {
+		if !MatchTopic(s.filter, topic) {
+			continue
+		}
+		if s.group == "" {
+			targets = append(targets, s.sub)
+			continue
+		}
+		key := s.group + "/" + s.filter
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], s.sub)
+	}
+	for _, key := range keys {
+		members := groups[key]
+		i := b.next[key] % len(members)
+		b.next[key] = i + 1
+		targets = append(targets, members[i])
+	}
+	b.mu.Unlock()
+
+	in := &inbound{msg: msg, refs: int32(len(targets))}
+	for _, sub := range targets {
+		select {
+		case sub.ch <- in:
+		case <-sub.done:
+		case <-b.closed:
+			return errMemoryClosed
+		}
+	}
+	return nil
+}
+
+// Subscribe is 订阅 topic, 订阅时先收到匹配的保留消息. 支持 WithShareGroup、WithWorkers、WithOrderKey.
+func (b *MemoryBroker) Subscribe(ctx context.Context, topic string, h Handler, opts ...SubscribeOption) error {
+	o := newSubscribeOptions(opts)
+	s := &memorySub{
+		filter: topic,
+		group:  o.group,
+		sub:    &emqttSub{ch: make(chan *inbound, 64), done: make(chan struct{})},
+	}
+	b.mu.Lock()
+	if isClosed(b) {
+		b.mu.Unlock()
+		return errMemoryClosed
+	}
+	b.subs = append(b.subs, s)
+	var retained []*Message
+	if o.group == "" {
+		for t, m := range b.retained {
+			if MatchTopic(topic, t) {
+				retained = append(retained, m)
+			}
+		}
+	}
+	b.mu.Unlock()
+	defer b.unsubscribe(s)
+
+	go func() {
+		for _, m := range retained {
+			select {
+			case s.sub.ch <- &inbound{msg: m, refs: 1}:
+			case <-s.sub.done:
+				return
+			}
+		}
+	}()
+	return consume(ctx, b, s.sub, Chain(h, o.middlewares...), o)
+}
+
+func (b *MemoryBroker) unsubscribe(s *memorySub) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, x := range b.subs {
+		if x == s {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			break
+		}
+	}
+	close(s.sub.done)
+}
+
+// Close is 关闭 broker, 所有订阅返回错误.
+func (b *MemoryBroker) Close() error {
+	b.once.Do(func() { close(b.closed) })
+	return nil
+}
+
+func (b *MemoryBroker) done() <-chan struct{} {
+	return b.closed
+}
+
+func (b *MemoryBroker) closeErr() error {
+	return errMemoryClosed
+}
@@ -0,0 +1,75 @@
+package mq_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zhgqiang/commongo/mq"
+)
+
+// subscribe 在后台订阅 topic, 返回收到消息的通道.
+func subscribe(t *testing.T, ctx context.Context, s mq.Subscriber, topic string, opts ...mq.SubscribeOption) <-chan *mq.Message {
+	ch := make(chan *mq.Message, 16)
+	go func() {
+		if err := s.Subscribe(ctx, topic, func(ctx context.Context, msg *mq.Message) error {
+			ch <- msg
+			return nil
+		}, opts...); err != nil && ctx.Err() == nil {
+			t.Error(err)
+		}
+	}()
+	return ch
+}
+
+func TestMemoryBroker(t *testing.T) {
+	b := mq.NewMemoryBroker()
+	defer b.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := b.Publish("dev/1/state", []byte("online"), mq.WithRetain(true)); err != nil {
+		t.Fatal(err)
+	}
+	all := subscribe(t, ctx, b, "dev/+/state")
+	select {
+	case msg := <-all:
+		if string(msg.Payload) != "online" || !msg.Retain {
+			t.Fatalf("保留消息错误: %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到保留消息")
+	}
+
+	g1 := subscribe(t, ctx, b, "dev/+/state", mq.WithShareGroup("g"))
+	g2 := subscribe(t, ctx, b, "dev/+/state", mq.WithShareGroup("g"))
+	time.Sleep(50 * time.Millisecond)
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		if err := b.Publish("dev/2/state", []byte("x"), mq.WithHeader("seq", "1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts := map[string]int{}
+	timeout := time.After(time.Second)
+	for counts["all"] < n || counts["g1"]+counts["g2"] < n {
+		select {
+		case msg := <-all:
+			if msg.Headers["seq"] != "1" {
+				t.Fatalf("消息头丢失: %+v", msg)
+			}
+			counts["all"]++
+		case <-g1:
+			counts["g1"]++
+		case <-g2:
+			counts["g2"]++
+		case <-timeout:
+			t.Fatalf("等待消息超时, 已收到 %v", counts)
+		}
+	}
+	if counts["all"] != n || counts["g1"]+counts["g2"] != n || counts["g1"] == 0 || counts["g2"] == 0 {
+		t.Fatalf("消息分配错误: %v", counts)
+	}
+}
@@ -0,0 +1,122 @@
+package mq
+
+import (
+	"context"
+	"time"
+)
+
+// HeaderContentEncoding is 消息压缩方式的消息头, 与 Compression 配置取值相同.
+const HeaderContentEncoding = "content-encoding"
+
+// HeaderMessageID is 消息ID的消息头, 与 WithMessageID 设置的值相同.
+const HeaderMessageID = "message-id"
+
+// Message is 收到的一条消息.
+type Message struct {
+	Topic   string
+	Payload []byte
+	Qos     byte
+	Retain  bool
+
+	// 以下字段仅在 broker 支持消息属性时有值, 如 MQTT 5.
+	Headers       map[string]string
+	ContentType   string
+	ResponseTopic string
+	CorrelationID string
+	Expiry        time.Duration
+}
+
+// Handler is 消息处理函数.
+type Handler func(ctx context.Context, msg *Message) error
+
+// SubscribeOption is 订阅选项.
+type SubscribeOption func(*subscribeOptions)
+
+// subscribeOptions 汇总订阅选项.
+type subscribeOptions struct {
+	qos      byte
+	headers  map[string]string
+	group    string
+	workers  int
+	orderKey func(*Message) string
+	noAck    bool
+	exchange string
+
+	middlewares []Middleware
+}
+
+// WithSubscribeQos is 设置订阅的 QoS 等级.
+func WithSubscribeQos(qos byte) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.qos = qos
+	}
+}
+
+// WithSubscribeHeader is 设置订阅报文的用户属性, 仅 MQTT 5 支持.
+func WithSubscribeHeader(key, value string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if o.headers == nil {
+			o.headers = make(map[string]string)
+		}
+		o.headers[key] = value
+	}
+}
+
+// WithShareGroup is 使用共享订阅 $share/<group>/<topic>, 同一分组的多个订阅者
+// 由 broker 负载均衡, 每条消息只投递给其中一个, 用于水平扩展的消费者.
+func WithShareGroup(group string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.group = group
+	}
+}
+
+// WithWorkers is 设置处理消息的协程数, 默认为 1 即按顺序处理.
+// 大于 1 时消息并发处理, 收到的消息数超过 n 时阻塞接收.
+func WithWorkers(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.workers = n
+	}
+}
+
+// WithOrderKey is 按 key 分配处理协程, key 相同的消息由同一协程按顺序处理,
+// 如按 topic 保证顺序:
+//
+//	WithOrderKey(func(msg *Message) string { return msg.Topic })
+func WithOrderKey(key func(*Message) string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.orderKey = key
+	}
+}
+
+// WithNoAckOnError is h 返回错误时不确认 QoS 1 的消息, 仅 MQTT 支持.
+// 使用持久会话(SessionExpiry 大于 0)时 broker 在重新连接后重新投递未确认的消息;
+// MQTT 5 按收到的顺序确认, 之后收到的消息在重新连接前也不会被确认.
+func WithNoAckOnError() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.noAck = true
+	}
+}
+
+// WithSubscribeExchange is 设置订阅绑定的 exchange, 仅 RabbitMQ 支持, 为空时使用配置的 Exchange.
+// 该 exchange 不会被声明, 需已存在.
+func WithSubscribeExchange(exchange string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.exchange = exchange
+	}
+}
+
+// filter 返回实际订阅的 topic 过滤器.
+func (o *subscribeOptions) filter(topic string) string {
+	if o.group == "" {
+		return topic
+	}
+	return "$share/" + o.group + "/" + topic
+}
+
+func newSubscribeOptions(opts []SubscribeOption) *subscribeOptions {
+	o := &subscribeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
@@ -0,0 +1,145 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Middleware is 消息处理中间件, 包装 Handler 以在处理前后执行公共逻辑.
+type Middleware func(Handler) Handler
+
+// Chain is 使用 mws 包装 h, 第一个中间件在最外层, 即最先执行.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// WithMiddleware is 为订阅的 Handler 添加中间件, 多次调用时按调用顺序追加, 先添加的在外层.
+func WithMiddleware(mws ...Middleware) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.middlewares = append(o.middlewares, mws...)
+	}
+}
+
+// WithConsumeMiddleware is 为 RabbitMQ Consume 的 Handler 添加中间件, 先添加的在外层.
+func WithConsumeMiddleware(mws ...Middleware) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.middlewares = append(o.middlewares, mws...)
+	}
+}
+
+// Recover is 返回捕获 panic 的中间件, panic 转换为包含调用栈的 Permanent 错误, 避免消费协程崩溃.
+func Recover() Middleware {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, msg *Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = Permanent(fmt.Errorf("处理topic %s的消息时panic.%v\n%s", msg.Topic, r, debug.Stack()))
+				}
+			}()
+			return h(ctx, msg)
+		}
+	}
+}
+
+// Logging is 返回记录消息处理结果的中间件, 字段包括 topic、size、duration 及 error,
+// 成功时为 Debug 级别, 失败时为 Error 级别. log 为空时使用 logger 包配置的 logrus 标准日志.
+func Logging(log logrus.FieldLogger) Middleware {
+	if log == nil {
+		log = logrus.StandardLogger()
+	}
+	return func(h Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			start := time.Now()
+			err := h(ctx, msg)
+			entry := log.WithFields(logrus.Fields{
+				"topic":    msg.Topic,
+				"size":     len(msg.Payload),
+				"duration": time.Since(start).String(),
+			})
+			if err != nil {
+				entry.WithError(err).Error("消息处理失败")
+			} else {
+				entry.Debug("消息处理完成")
+			}
+			return err
+		}
+	}
+}
+
+// HandlerMetrics is 消息处理指标的接收者, 可对接 Prometheus 等监控系统.
+type HandlerMetrics interface {
+	// Observe 记录一条消息的处理耗时及结果.
+	Observe(topic string, d time.Duration, err error)
+}
+
+// MetricsFunc is 将函数适配为 HandlerMetrics.
+type MetricsFunc func(topic string, d time.Duration, err error)
+
+// Observe is 调用 f.
+func (f MetricsFunc) Observe(topic string, d time.Duration, err error) {
+	f(topic, d, err)
+}
+
+// Metrics is 返回记录消息处理耗时及结果的中间件.
+func Metrics(m HandlerMetrics) Middleware {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			start := time.Now()
+			err := h(ctx, msg)
+			m.Observe(msg.Topic, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// Retry is 返回在进程内重试的中间件, 失败后等待 backoff 重试, 每次等待时间加倍, 最多 10 秒,
+// 共执行 attempts 次. Permanent 错误不重试, ctx 结束时返回最后一次的错误.
+// 与 RabbitMQ 的 WithRetry 不同, 重试期间消息不会确认, 也不会让出处理协程.
+func Retry(attempts int, backoff time.Duration) Middleware {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			wait := backoff
+			var err error
+			for i := 0; i < attempts || i == 0; i++ {
+				if i > 0 {
+					select {
+					case <-ctx.Done():
+						return err
+					case <-time.After(wait):
+					}
+					if wait *= 2; wait > 10*time.Second {
+						wait = 10 * time.Second
+					}
+				}
+				if err = h(ctx, msg); err == nil {
+					return nil
+				}
+				var perm *permanentError
+				if errors.As(err, &perm) {
+					return err
+				}
+			}
+			return err
+		}
+	}
+}
+
+// Timeout is 返回限制处理时间的中间件, h 收到的 ctx 在 d 后结束.
+// 中间件不会强行中断 h, h 需要检查 ctx 或将其传给数据库、网络等调用.
+func Timeout(d time.Duration) Middleware {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return h(ctx, msg)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package mq_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/zhgqiang/commongo/mq"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) mq.Middleware {
+		return func(h mq.Handler) mq.Handler {
+			return func(ctx context.Context, msg *mq.Message) error {
+				order = append(order, name)
+				return h(ctx, msg)
+			}
+		}
+	}
+	h := mq.Chain(func(ctx context.Context, msg *mq.Message) error {
+		order = append(order, "h")
+		return nil
+	}, mw("a"), mw("b"))
+	h(context.Background(), &mq.Message{})
+	if strings.Join(order, ",") != "a,b,h" {
+		t.Fatalf("执行顺序 %v, 期望 a,b,h", order)
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	ctx := context.Background()
+	msg := &mq.Message{Topic: "dev/1", Payload: []byte("x")}
+
+	h := mq.Recover()(func(ctx context.Context, msg *mq.Message) error {
+		panic("boom")
+	})
+	if err := h(ctx, msg); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("Recover 应返回 panic 错误: %v", err)
+	}
+
+	calls := 0
+	h = mq.Retry(3, time.Millisecond)(func(ctx context.Context, msg *mq.Message) error {
+		if calls++; calls < 3 {
+			return errors.New("临时错误")
+		}
+		return nil
+	})
+	if err := h(ctx, msg); err != nil || calls != 3 {
+		t.Fatalf("Retry = %v, 执行 %d 次, 期望成功且执行 3 次", err, calls)
+	}
+	calls = 0
+	h = mq.Retry(3, time.Millisecond)(func(ctx context.Context, msg *mq.Message) error {
+		calls++
+		return mq.Permanent(errors.New("永久错误"))
+	})
+	if err := h(ctx, msg); err == nil || calls != 1 {
+		t.Fatalf("Permanent 错误不应重试: %v, 执行 %d 次", err, calls)
+	}
+
+	h = mq.Timeout(10 * time.Millisecond)(func(ctx context.Context, msg *mq.Message) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := h(ctx, msg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Timeout 应结束 ctx: %v", err)
+	}
+
+	var observed []string
+	log, hook := test.NewNullLogger()
+	log.SetLevel(logrus.DebugLevel)
+	h = mq.Chain(func(ctx context.Context, msg *mq.Message) error {
+		return errors.New("失败")
+	}, mq.Logging(log), mq.Metrics(mq.MetricsFunc(func(topic string, d time.Duration, err error) {
+		observed = append(observed, topic)
+	})))
+	h(ctx, msg)
+	if len(observed) != 1 || observed[0] != "dev/1" {
+		t.Fatalf("Metrics 记录 %v", observed)
+	}
+	e := hook.LastEntry()
+	if e == nil || e.Level != logrus.ErrorLevel || e.Data["topic"] != "dev/1" {
+		t.Fatalf("Logging 记录 %+v", e)
+	}
+}
+
+func TestWithMiddleware(t *testing.T) {
+	b := mq.NewMemoryBroker()
+	defer b.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := subscribe(t, ctx, b, "dev/#", mq.WithMiddleware(func(h mq.Handler) mq.Handler {
+		return func(ctx context.Context, msg *mq.Message) error {
+			msg.Headers = map[string]string{"mw": "1"}
+			return h(ctx, msg)
+		}
+	}))
+	time.Sleep(20 * time.Millisecond)
+	b.Publish("dev/1", []byte("x"))
+	select {
+	case msg := <-ch:
+		if msg.Headers["mw"] != "1" {
+			t.Fatalf("中间件未执行: %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到消息")
+	}
+}
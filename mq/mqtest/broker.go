@@ -0,0 +1,459 @@
+/*
+Package mqtest 提供用于测试的进程内 MQTT 3.1.1 broker.
+
+Broker 监听本地随机端口, 支持发布/订阅、保留消息、遗嘱消息、$share 共享订阅以及 QoS 0/1,
+并记录收到的所有消息用于断言:
+
+	b, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	emqtt := b.Config()
+	emqtt.Send("hello")
+	msgs, err := b.WaitMessages(emqtt.TopicName, 1, time.Second)
+*/
+package mqtest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhgqiang/commongo/mq"
+)
+
+// Message is broker 收到的一条消息.
+type Message struct {
+	ClientID string
+	Topic    string
+	Payload  []byte
+	Qos      byte
+	Retain   bool
+	Time     time.Time
+}
+
+// Broker is 进程内 MQTT broker.
+type Broker struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	clients  map[*client]struct{}
+	retained map[string]Message
+	messages []Message
+	notify   chan struct{}
+	// shareNext 为每个共享订阅的轮询位置
+	shareNext map[string]int
+
+	wg sync.WaitGroup
+}
+
+// NewBroker is 在本地随机端口上启动 broker.
+func NewBroker() (*Broker, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &Broker{
+		ln:        ln,
+		clients:   make(map[*client]struct{}),
+		retained:  make(map[string]Message),
+		notify:    make(chan struct{}),
+		shareNext: make(map[string]int),
+	}
+	b.wg.Add(1)
+	go b.serve()
+	return b, nil
+}
+
+// Addr is broker 监听地址.
+func (b *Broker) Addr() string {
+	return b.ln.Addr().String()
+}
+
+// Port is broker 监听端口.
+func (b *Broker) Port() int {
+	return b.ln.Addr().(*net.TCPAddr).Port
+}
+
+// Config is 返回连接该 broker 的 Emqtt 配置, TopicName 默认为 test.
+func (b *Broker) Config() mq.Emqtt {
+	return mq.Emqtt{
+		Host:      "127.0.0.1",
+		Port:      b.Port(),
+		TopicName: "test",
+		Timeout:   5,
+	}
+}
+
+// Close is 关闭 broker 及所有客户端连接.
+func (b *Broker) Close() error {
+	err := b.ln.Close()
+	b.mu.Lock()
+	for c := range b.clients {
+		c.conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	return err
+}
+
+// Kick is 强制断开 clientID 的连接, 用于模拟网络中断, 断开后会发布其遗嘱消息.
+func (b *Broker) Kick(clientID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		if c.id == clientID {
+			c.conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// Messages is 返回 broker 收到的全部消息, 包括遗嘱消息.
+func (b *Broker) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.messages...)
+}
+
+// Retained is 返回 topic 上的保留消息.
+func (b *Broker) Retained(topic string) (Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.retained[topic]
+	return m, ok
+}
+
+// WaitMessages is 等待直到收到 n 条匹配 filter 的消息, 超时返回错误.
+func (b *Broker) WaitMessages(filter string, n int, timeout time.Duration) ([]Message, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		b.mu.Lock()
+		var msgs []Message
+		for _, m := range b.messages {
+			if mq.MatchTopic(filter, m.Topic) {
+				msgs = append(msgs, m)
+			}
+		}
+		notify := b.notify
+		b.mu.Unlock()
+		if len(msgs) >= n {
+			return msgs, nil
+		}
+		select {
+		case <-notify:
+		case <-deadline.C:
+			return msgs, fmt.Errorf("等待%s的消息超时,期望%d条,实际%d条", filter, n, len(msgs))
+		}
+	}
+}
+
+func (b *Broker) serve() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &client{broker: b, conn: conn, subs: make(map[string]byte)}
+		b.mu.Lock()
+		b.clients[c] = struct{}{}
+		b.mu.Unlock()
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			c.serve()
+		}()
+	}
+}
+
+// publish 记录消息并分发给匹配的订阅者.
+func (b *Broker) publish(m Message) {
+	b.mu.Lock()
+	b.messages = append(b.messages, m)
+	close(b.notify)
+	b.notify = make(chan struct{})
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+	var targets []*client
+	var qos []byte
+	shared := make(map[string][]*client)
+	for c := range b.clients {
+		if q, ok := c.match(m.Topic); ok {
+			targets = append(targets, c)
+			qos = append(qos, q)
+		}
+		for f := range c.subs {
+			if _, filter, ok := splitShare(f); ok && mq.MatchTopic(filter, m.Topic) {
+				shared[f] = append(shared[f], c)
+			}
+		}
+	}
+	// 同一共享订阅的成员按客户端ID轮询, 每条消息只投递给其中一个
+	for f, members := range shared {
+		sort.Slice(members, func(i, j int) bool { return members[i].id < members[j].id })
+		c := members[b.shareNext[f]%len(members)]
+		b.shareNext[f]++
+		targets = append(targets, c)
+		qos = append(qos, c.subs[f])
+	}
+	b.mu.Unlock()
+
+	for i, c := range targets {
+		q := m.Qos
+		if qos[i] < q {
+			q = qos[i]
+		}
+		// 转发给订阅者时清除保留标志
+		c.deliver(m.Topic, m.Payload, q, false)
+	}
+}
+
+func (b *Broker) remove(c *client) {
+	b.mu.Lock()
+	delete(b.clients, c)
+	b.mu.Unlock()
+}
+
+// client 为一个客户端连接.
+type client struct {
+	broker *Broker
+	conn   net.Conn
+	id     string
+	will   *Message
+
+	wmu   sync.Mutex
+	msgID uint16
+
+	// subs 由 broker.mu 保护
+	subs map[string]byte
+}
+
+func (c *client) serve() {
+	defer c.broker.remove(c)
+	defer c.conn.Close()
+
+	r := bufio.NewReader(c.conn)
+	p, err := readPacket(r)
+	if err != nil || p.typ != packetConnect || c.connect(p) != nil {
+		return
+	}
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			break
+		}
+		switch p.typ {
+		case packetPublish:
+			err = c.handlePublish(p)
+		case packetSubscribe:
+			err = c.handleSubscribe(p)
+		case packetUnsubscribe:
+			err = c.handleUnsubscribe(p)
+		case packetPingReq:
+			err = c.write(packetPingResp, 0, nil)
+		case packetDisconnect:
+			c.will = nil
+			return
+		}
+		if err != nil {
+			break
+		}
+	}
+	if c.will != nil {
+		c.will.Time = time.Now()
+		c.broker.publish(*c.will)
+	}
+}
+
+func (c *client) connect(p *packet) error {
+	r := &reader{b: p.body}
+	name := r.string()
+	level := r.uint8()
+	flags := r.uint8()
+	r.uint16() // keep alive
+	id := r.string()
+	c.broker.mu.Lock()
+	c.id = id
+	c.broker.mu.Unlock()
+	if flags&0x04 != 0 {
+		c.will = &Message{
+			ClientID: id,
+			Topic:    r.string(),
+			Payload:  r.bytes(),
+			Qos:      flags >> 3 & 0x03,
+			Retain:   flags&0x20 != 0,
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if !(name == "MQIsdp" && level == 3) && !(name == "MQTT" && level == 4) {
+		c.write(packetConnAck, 0, []byte{0, 1})
+		return fmt.Errorf("不支持的协议%s %d", name, level)
+	}
+	return c.write(packetConnAck, 0, []byte{0, 0})
+}
+
+func (c *client) handlePublish(p *packet) error {
+	r := &reader{b: p.body}
+	m := Message{
+		ClientID: c.id,
+		Topic:    r.string(),
+		Qos:      p.flags >> 1 & 0x03,
+		Retain:   p.flags&0x01 != 0,
+		Time:     time.Now(),
+	}
+	var id uint16
+	if m.Qos > 0 {
+		id = r.uint16()
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if m.Qos > 1 {
+		return fmt.Errorf("不支持QoS %d", m.Qos)
+	}
+	m.Payload = append([]byte(nil), r.b...)
+	c.broker.publish(m)
+	if m.Qos == 1 {
+		return c.write(packetPubAck, 0, binary.BigEndian.AppendUint16(nil, id))
+	}
+	return nil
+}
+
+func (c *client) handleSubscribe(p *packet) error {
+	r := &reader{b: p.body}
+	id := r.uint16()
+	var filters []string
+	var granted []byte
+	for len(r.b) > 0 && r.err == nil {
+		filter := r.string()
+		qos := r.uint8()
+		if qos > 1 {
+			qos = 1
+		}
+		filters = append(filters, filter)
+		granted = append(granted, qos)
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	b := c.broker
+	b.mu.Lock()
+	var retained []Message
+	for i, f := range filters {
+		c.subs[f] = granted[i]
+		// 共享订阅不接收保留消息
+		if _, _, ok := splitShare(f); ok {
+			continue
+		}
+		for topic, m := range b.retained {
+			if mq.MatchTopic(f, topic) {
+				if m.Qos > granted[i] {
+					m.Qos = granted[i]
+				}
+				retained = append(retained, m)
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	ack := binary.BigEndian.AppendUint16(nil, id)
+	if err := c.write(packetSubAck, 0, append(ack, granted...)); err != nil {
+		return err
+	}
+	for _, m := range retained {
+		if err := c.deliver(m.Topic, m.Payload, m.Qos, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *client) handleUnsubscribe(p *packet) error {
+	r := &reader{b: p.body}
+	id := r.uint16()
+	c.broker.mu.Lock()
+	for len(r.b) > 0 && r.err == nil {
+		delete(c.subs, r.string())
+	}
+	c.broker.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	return c.write(packetUnsubAck, 0, binary.BigEndian.AppendUint16(nil, id))
+}
+
+// match 返回非共享订阅中匹配 topic 的最高 QoS, 调用方需持有 broker.mu.
+func (c *client) match(topic string) (byte, bool) {
+	var qos byte
+	var ok bool
+	for f, q := range c.subs {
+		if strings.HasPrefix(f, "$share/") {
+			continue
+		}
+		if mq.MatchTopic(f, topic) {
+			ok = true
+			if q > qos {
+				qos = q
+			}
+		}
+	}
+	return qos, ok
+}
+
+// splitShare 解析共享订阅 $share/<group>/<filter>.
+func splitShare(f string) (group, filter string, ok bool) {
+	if !strings.HasPrefix(f, "$share/") {
+		return "", "", false
+	}
+	parts := strings.SplitN(f, "/", 3)
+	if len(parts) < 3 || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// deliver 向客户端投递消息, QoS 1 的 PUBACK 不做跟踪.
+func (c *client) deliver(topic string, payload []byte, qos byte, retain bool) error {
+	flags := qos << 1
+	if retain {
+		flags |= 0x01
+	}
+	body := appendString(nil, topic)
+	if qos > 0 {
+		c.wmu.Lock()
+		c.msgID++
+		if c.msgID == 0 {
+			c.msgID = 1
+		}
+		id := c.msgID
+		c.wmu.Unlock()
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	return c.write(packetPublish, flags, append(body, payload...))
+}
+
+func (c *client) write(typ, flags byte, body []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.conn.Write(encodePacket(typ, flags, body))
+	return err
+}
@@ -0,0 +1,95 @@
+package mqtest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zhgqiang/commongo/mq"
+	"github.com/zhgqiang/commongo/mq/mqtest"
+)
+
+func TestBroker_Publish(t *testing.T) {
+	b, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	emqtt := b.Config()
+	if err := emqtt.SendTopicValue("factory/l1/d1", map[string]int{"t": 1}, mq.WithQos(1), mq.WithRetain(true)); err != nil {
+		t.Fatal("发送失败", err)
+	}
+	msgs, err := b.WaitMessages("factory/#", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msgs[0].Payload) != `{"t":1}` || msgs[0].Qos != 1 {
+		t.Fatalf("消息不符合预期: %+v", msgs[0])
+	}
+	if _, ok := b.Retained("factory/l1/d1"); !ok {
+		t.Fatal("保留消息不存在")
+	}
+}
+
+func TestBroker_Will(t *testing.T) {
+	b, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	emqtt := b.Config()
+	emqtt.ClientID = "dev1"
+	emqtt.WillTopic = "status/dev1"
+	emqtt.WillPayload = "offline"
+	emqtt.WillRetain = true
+	emqtt.BirthPayload = "online"
+	if err := emqtt.Connect(); err != nil {
+		t.Fatal("连接失败", err)
+	}
+	if _, err := b.WaitMessages("status/dev1", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := b.Retained("status/dev1"); !ok || string(m.Payload) != "online" {
+		t.Fatalf("上线消息不符合预期: %+v", m)
+	}
+
+	if !b.Kick("dev1") {
+		t.Fatal("客户端不存在")
+	}
+	if _, err := b.WaitMessages("status/dev1", 2, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := b.Retained("status/dev1"); string(m.Payload) != "offline" {
+		t.Fatalf("遗嘱消息不符合预期: %+v", m)
+	}
+}
+
+func TestBroker_RetainedQos(t *testing.T) {
+	b, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	emqtt := b.Config()
+	if err := emqtt.Publish("config/d1", []byte("v1"), mq.WithQos(1), mq.WithRetain(true)); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan *mq.Message, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go emqtt.Subscribe(ctx, "config/#", func(ctx context.Context, msg *mq.Message) error {
+		got <- msg
+		return nil
+	}, mq.WithSubscribeQos(0))
+	select {
+	case msg := <-got:
+		if !msg.Retain || msg.Qos != 0 {
+			t.Fatalf("保留消息应按订阅的 QoS 0 投递: %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到保留消息")
+	}
+}
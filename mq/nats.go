@@ -0,0 +1,473 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Nats is 配置信息.
+type Nats struct {
+	Host      string `json:"host" toml:"host" description:"NATS地址"`
+	Port      int    `json:"port" toml:"port" description:"NATS端口"`
+	Username  string `json:"username" toml:"username" description:"NATS访问用户名"`
+	Password  string `json:"password" toml:"password" description:"NATS访问密码"`
+	Token     string `json:"token" toml:"token" description:"NATS访问令牌,与用户名密码二选一"`
+	TopicName string `json:"topicName" toml:"topicName" description:"Send、SendValue使用的topic"`
+	Timeout   int    `json:"timeout" toml:"timeout" description:"连接、请求及JetStream确认超时时间,单位秒,默认10"`
+
+	// Stream 不为空时 Publish 通过 JetStream 发送并等待确认, Subscribe 使用 JetStream 消费者.
+	Stream         string   `json:"stream" toml:"stream" description:"JetStream流名称,为空时不使用JetStream"`
+	StreamSubjects []string `json:"streamSubjects" toml:"streamSubjects" description:"流不存在时按该topic列表创建文件存储的流"`
+	MaxDeliver     int      `json:"maxDeliver" toml:"maxDeliver" description:"JetStream消息最大投递次数,超过后不再投递,默认不限制"`
+
+	Codec       string `json:"codec" toml:"codec" description:"消息编码:json、msgpack、cbor、protobuf,默认json"`
+	Compression string `json:"compression" toml:"compression" description:"消息压缩:gzip、zstd,默认不压缩"`
+
+	// Recorder 不为空时录制发送和收到的消息, topic 为 MQTT 风格.
+	Recorder *Recorder `json:"-" toml:"-"`
+
+	sess *natsSession
+}
+
+// natsSession 保存 NATS 长连接, 断线重连由客户端自动完成, 重连失败后连接关闭.
+type natsSession struct {
+	mu     sync.Mutex
+	nc     *nats.Conn
+	js     nats.JetStreamContext
+	closed chan struct{}
+}
+
+const (
+	defaultNatsTimeout = 10
+
+	// natsNakDelay 为 JetStream 消息处理失败后首次重新投递的延迟, 之后每次加倍, 最大为 natsMaxNakDelay.
+	natsNakDelay    = 100 * time.Millisecond
+	natsMaxNakDelay = 10 * time.Second
+
+	// natsHeaderContentType 为消息内容类型的消息头.
+	natsHeaderContentType = "Content-Type"
+	// natsHeaderCorrelationID 为关联ID的消息头.
+	natsHeaderCorrelationID = "Correlation-Id"
+	// natsHeaderMsgID 为消息ID的消息头, JetStream 据此去重.
+	natsHeaderMsgID = "Nats-Msg-Id"
+)
+
+var errNatsClosed = errors.New("NATS连接已关闭")
+
+// natsSessionMu 保护 Nats.sess 的创建, 使并发的首次调用共用同一会话.
+var natsSessionMu sync.Mutex
+
+// session 返回会话, 不存在时创建.
+func (p *Nats) session() *natsSession {
+	natsSessionMu.Lock()
+	defer natsSessionMu.Unlock()
+	if p.sess == nil {
+		p.sess = &natsSession{}
+	}
+	return p.sess
+}
+
+func (p *Nats) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return defaultNatsTimeout * time.Second
+	}
+	return time.Duration(p.Timeout) * time.Second
+}
+
+// url 返回 NATS 连接地址.
+func (p *Nats) url() string {
+	return fmt.Sprintf("nats://%s:%d", p.Host, p.Port)
+}
+
+// Connect is 建立 NATS 长连接, Publish、Subscribe 未连接时会自动调用.
+// 配置了 Stream 时检查流是否存在, 不存在且配置了 StreamSubjects 时创建.
+func (p *Nats) Connect() error {
+	s := p.session()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nc != nil && !s.nc.IsClosed() {
+		return nil
+	}
+	closed := make(chan struct{})
+	opts := []nats.Option{
+		nats.Timeout(p.timeout()),
+		nats.MaxReconnects(-1),
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }),
+	}
+	if p.Token != "" {
+		opts = append(opts, nats.Token(p.Token))
+	} else if p.Username != "" {
+		opts = append(opts, nats.UserInfo(p.Username, p.Password))
+	}
+	nc, err := nats.Connect(p.url(), opts...)
+	if err != nil {
+		return fmt.Errorf("NATS连接失败.%v", err)
+	}
+	var js nats.JetStreamContext
+	if p.Stream != "" {
+		if js, err = p.declareStream(nc); err != nil {
+			nc.Close()
+			return err
+		}
+	}
+	s.nc, s.js, s.closed = nc, js, closed
+	return nil
+}
+
+// declareStream 检查 Stream 是否存在, 不存在时按 StreamSubjects 创建.
+func (p *Nats) declareStream(nc *nats.Conn) (nats.JetStreamContext, error) {
+	js, err := nc.JetStream(nats.MaxWait(p.timeout()))
+	if err != nil {
+		return nil, fmt.Errorf("NATS JetStream初始化失败.%v", err)
+	}
+	_, err = js.StreamInfo(p.Stream)
+	if err == nil {
+		return js, nil
+	}
+	if !errors.Is(err, nats.ErrStreamNotFound) || len(p.StreamSubjects) == 0 {
+		return nil, fmt.Errorf("NATS JetStream流%s不可用.%v", p.Stream, err)
+	}
+	subjects := make([]string, len(p.StreamSubjects))
+	for i, t := range p.StreamSubjects {
+		subjects[i] = Subject(t)
+	}
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     p.Stream,
+		Subjects: subjects,
+		Storage:  nats.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("NATS JetStream流%s创建失败.%v", p.Stream, err)
+	}
+	return js, nil
+}
+
+// Close is 关闭 NATS 长连接, 关闭前发送缓冲中的消息.
+func (p *Nats) Close() error {
+	s := p.session()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nc := s.nc
+	if nc == nil {
+		return nil
+	}
+	s.nc, s.js = nil, nil
+	if err := nc.Drain(); err != nil {
+		nc.Close()
+	}
+	return nil
+}
+
+// acquire 返回当前连接, 未连接或连接已关闭时重新连接.
+func (p *Nats) acquire() (*natsSession, error) {
+	s := p.session()
+	if nc, _ := s.conn(); nc != nil && !nc.IsClosed() {
+		return s, nil
+	}
+	if err := p.Connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// conn 返回连接及 JetStream 上下文.
+func (s *natsSession) conn() (*nats.Conn, nats.JetStreamContext) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nc, s.js
+}
+
+func (s *natsSession) done() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *natsSession) closeErr() error {
+	return errNatsClosed
+}
+
+// Send is NATS 向 TopicName 发送 msg, 内容类型为 text/plain.
+func (p *Nats) Send(msg string, opts ...PublishOption) error {
+	return p.Publish(p.TopicName, []byte(msg), textOptions(opts)...)
+}
+
+// SendValue is NATS 按 Codec 编码、Compression 压缩后向 TopicName 发送 val,
+// 内容类型和压缩方式通过消息头发送, 消费者可使用 DecodePayload 自动解码.
+func (p *Nats) SendValue(val interface{}, opts ...PublishOption) error {
+	codec, err := GetCodec(p.Codec)
+	if err != nil {
+		return err
+	}
+	body, err := EncodePayload(codec, p.Compression, val)
+	if err != nil {
+		return err
+	}
+	opts = append([]PublishOption{WithContentType(codec.ContentType())}, opts...)
+	if p.Compression != "" {
+		opts = append(opts, WithHeader(HeaderContentEncoding, p.Compression))
+	}
+	return p.Publish(p.TopicName, body, opts...)
+}
+
+// Publish is NATS 向 topic 发送原始数据, subject 为 Subject(topic), 即 a/b/c 转换为 a.b.c,
+// topic 不能包含 ., 否则返回 Permanent 错误.
+// 支持 WithHeader、WithContentType、WithCorrelationID、WithReplyTo, 不支持 QoS、保留消息和有效期.
+// 配置了 Stream 时通过 JetStream 发送并等待确认, WithMessageID 用于 JetStream 去重.
+func (p *Nats) Publish(topic string, payload []byte, opts ...PublishOption) error {
+	o := newPublishOptions(opts)
+	s, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	nc, js := s.conn()
+	if nc == nil {
+		return errNatsClosed
+	}
+	subject, err := natsSubject(topic)
+	if err != nil {
+		return err
+	}
+	msg := natsMsg(subject, payload, o)
+	if js != nil {
+		if _, err := js.PublishMsg(msg); err != nil {
+			return fmt.Errorf("NATS JetStream发送失败.%v", err)
+		}
+	} else if err := nc.PublishMsg(msg); err != nil {
+		return fmt.Errorf("NATS发送失败.%v", err)
+	}
+	p.record(DIRECTION_PUBLISH, topic, payload)
+	return nil
+}
+
+// Reply is 向 Request 的请求方回复, 总是直接发送, 不经过 JetStream.
+func (p *Nats) Reply(req *Message, payload []byte, opts ...PublishOption) error {
+	if req.ResponseTopic == "" {
+		return errors.New("NATS消息没有回复地址")
+	}
+	s, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	nc, _ := s.conn()
+	if nc == nil {
+		return errNatsClosed
+	}
+	o := newPublishOptions(opts)
+	if o.correlationID == "" {
+		o.correlationID = req.CorrelationID
+	}
+	if err := nc.PublishMsg(natsMsg(req.ResponseTopic, payload, o)); err != nil {
+		return fmt.Errorf("NATS回复失败.%v", err)
+	}
+	return nil
+}
+
+// Request is 向 topic 发送请求并等待一个回复, ctx 没有截止时间时使用 Timeout.
+// 请求总是直接发送, 不经过 JetStream; 没有订阅者时立即返回错误.
+func (p *Nats) Request(ctx context.Context, topic string, payload []byte, opts ...PublishOption) (*Message, error) {
+	s, err := p.acquire()
+	if err != nil {
+		return nil, err
+	}
+	nc, _ := s.conn()
+	if nc == nil {
+		return nil, errNatsClosed
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout())
+		defer cancel()
+	}
+	subject, err := natsSubject(topic)
+	if err != nil {
+		return nil, err
+	}
+	o := newPublishOptions(opts)
+	o.replyTo = ""
+	resp, err := nc.RequestMsgWithContext(ctx, natsMsg(subject, payload, o))
+	if err != nil {
+		return nil, fmt.Errorf("NATS请求%s失败.%v", topic, err)
+	}
+	return natsMessage(resp, false), nil
+}
+
+// Subscribe is 订阅 topic 并使用 h 处理收到的消息, 阻塞直到 ctx 结束或连接关闭.
+// topic 可以使用 MQTT 通配符, 按 Subject 转换, a/# 不匹配 a 本身, 不能包含 ..
+// WithShareGroup 映射为 NATS 队列组, 同一分组的订阅者分摊消息; 支持 WithWorkers、WithOrderKey.
+// 请求的回复地址为 Message.ResponseTopic, 使用 Reply 回复.
+// 配置了 Stream 时使用 JetStream 推送消费者, h 返回 nil 时确认, 返回错误时拒绝(Nak)由服务端延迟重新投递,
+// 延迟从 100 毫秒开始每次加倍, 最大 10 秒, 投递次数超过 MaxDeliver 后不再投递:
+// 未指定分组时为只接收新消息的临时消费者, 指定分组时为以分组名命名的持久消费者, 重启后从上次确认的位置继续.
+func (p *Nats) Subscribe(ctx context.Context, topic string, h Handler, opts ...SubscribeOption) error {
+	o := newSubscribeOptions(opts)
+	subject, err := natsSubject(topic)
+	if err != nil {
+		return err
+	}
+	s, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	nc, js := s.conn()
+	if nc == nil {
+		return errNatsClosed
+	}
+	sub := &emqttSub{ch: make(chan *inbound), done: make(chan struct{})}
+	defer close(sub.done)
+	jetStream := js != nil
+	cb := func(m *nats.Msg) {
+		in := &inbound{msg: natsMessage(m, jetStream), refs: 1}
+		if jetStream {
+			in.ackFn = func() { m.Ack() }
+			in.nackFn = func() { m.NakWithDelay(nakDelay(m)) }
+		}
+		select {
+		case sub.ch <- in:
+		case <-sub.done:
+		}
+	}
+
+	var ns *nats.Subscription
+	if jetStream {
+		sopts := []nats.SubOpt{nats.BindStream(p.Stream), nats.ManualAck(), nats.AckExplicit()}
+		if p.MaxDeliver > 0 {
+			sopts = append(sopts, nats.MaxDeliver(p.MaxDeliver))
+		}
+		if o.group == "" {
+			ns, err = js.Subscribe(subject, cb, append(sopts, nats.DeliverNew())...)
+		} else {
+			ns, err = js.QueueSubscribe(subject, o.group, cb, append(sopts, nats.Durable(o.group))...)
+		}
+	} else if o.group == "" {
+		ns, err = nc.Subscribe(subject, cb)
+	} else {
+		ns, err = nc.QueueSubscribe(subject, o.group, cb)
+	}
+	if err != nil {
+		return fmt.Errorf("NATS订阅失败.%v", err)
+	}
+	defer ns.Unsubscribe()
+	h = Chain(h, o.middlewares...)
+	if p.Recorder != nil {
+		h = p.Recorder.Handler("nats", h)
+	}
+	return consume(ctx, s, sub, h, o)
+}
+
+// nakDelay 按 JetStream 消息 m 的投递次数返回重新投递的延迟.
+func nakDelay(m *nats.Msg) time.Duration {
+	md, err := m.Metadata()
+	if err != nil {
+		return natsNakDelay
+	}
+	delay := natsNakDelay
+	for i := uint64(1); i < md.NumDelivered && delay < natsMaxNakDelay; i++ {
+		delay *= 2
+	}
+	if delay > natsMaxNakDelay {
+		return natsMaxNakDelay
+	}
+	return delay
+}
+
+// record 录制消息.
+func (p *Nats) record(direction Direction, topic string, payload []byte) {
+	if p.Recorder != nil {
+		p.Recorder.Record(Record{
+			Broker:    "nats",
+			Direction: direction,
+			Topic:     topic,
+			Payload:   payload,
+		})
+	}
+}
+
+// natsMsg 按发送选项生成 NATS 消息.
+func natsMsg(subject string, payload []byte, o *publishOptions) *nats.Msg {
+	msg := &nats.Msg{Subject: subject, Reply: o.replyTo, Data: payload}
+	if len(o.headers) == 0 && o.contentType == "" && o.correlationID == "" && o.messageID == "" {
+		return msg
+	}
+	msg.Header = make(nats.Header, len(o.headers)+3)
+	for k, v := range o.headers {
+		msg.Header.Set(k, v)
+	}
+	if o.contentType != "" {
+		msg.Header.Set(natsHeaderContentType, o.contentType)
+	}
+	if o.correlationID != "" {
+		msg.Header.Set(natsHeaderCorrelationID, o.correlationID)
+	}
+	if o.messageID != "" {
+		msg.Header.Set(natsHeaderMsgID, o.messageID)
+	}
+	return msg
+}
+
+// natsMessage 将 NATS 消息转换为 Message, Topic 为 TopicFromSubject(subject).
+// JetStream 消息的回复地址用于确认, 不作为 ResponseTopic.
+func natsMessage(m *nats.Msg, jetStream bool) *Message {
+	msg := &Message{
+		Topic:   TopicFromSubject(m.Subject),
+		Payload: m.Data,
+	}
+	if !jetStream {
+		msg.ResponseTopic = m.Reply
+	}
+	for k, v := range m.Header {
+		if len(v) == 0 {
+			continue
+		}
+		switch k {
+		case natsHeaderContentType:
+			msg.ContentType = v[0]
+			continue
+		case natsHeaderCorrelationID:
+			msg.CorrelationID = v[0]
+			continue
+		case natsHeaderMsgID:
+			k = HeaderMessageID
+		}
+		if msg.Headers == nil {
+			msg.Headers = make(map[string]string, len(m.Header))
+		}
+		msg.Headers[k] = v[0]
+	}
+	return msg
+}
+
+// natsSubject 返回 topic 对应的 subject, topic 包含 . 时返回 Permanent 错误,
+// 否则 . 会成为 subject 的层级分隔符, 收到的 Topic 与发送的不同.
+func natsSubject(topic string) (string, error) {
+	if strings.Contains(topic, ".") {
+		return "", Permanent(fmt.Errorf("NATS的topic %s不能包含.,.是subject的层级分隔符", topic))
+	}
+	return Subject(topic), nil
+}
+
+// Subject is 将 MQTT topic 或过滤器转换为 NATS subject,
+// / 转换为 ., 单层通配符 + 转换为 *, 多层通配符 # 转换为 >.
+func Subject(topic string) string {
+	levels := strings.Split(topic, "/")
+	for i, l := range levels {
+		switch l {
+		case "+":
+			levels[i] = "*"
+		case "#":
+			levels[i] = ">"
+		}
+	}
+	return strings.Join(levels, ".")
+}
+
+// TopicFromSubject is 将 NATS subject 转换为 MQTT topic, Subject 的逆操作.
+func TopicFromSubject(subject string) string {
+	return strings.ReplaceAll(subject, ".", "/")
+}
@@ -0,0 +1,223 @@
+package mq_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+
+	"github.com/zhgqiang/commongo/mq"
+)
+
+// runNats 启动开启 JetStream 的内嵌 NATS 服务, 返回连接配置.
+func runNats(t *testing.T) *mq.Nats {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS服务启动超时")
+	}
+	t.Cleanup(s.Shutdown)
+	return &mq.Nats{Host: "127.0.0.1", Port: s.Addr().(*net.TCPAddr).Port, Timeout: 2}
+}
+
+func TestNats_PubSub(t *testing.T) {
+	p := runNats(t)
+	defer p.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	all := subscribe(t, ctx, p, "dev/+/state")
+	g1 := subscribe(t, ctx, p, "dev/#", mq.WithShareGroup("g"))
+	g2 := subscribe(t, ctx, p, "dev/#", mq.WithShareGroup("g"))
+	time.Sleep(50 * time.Millisecond)
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		err := p.Publish("dev/1/state", []byte("online"), mq.WithHeader("seq", "1"), mq.WithContentType("text/plain"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts := map[string]int{}
+	timeout := time.After(2 * time.Second)
+	for counts["all"] < n || counts["g"] < n {
+		select {
+		case msg := <-all:
+			if msg.Topic != "dev/1/state" || msg.Headers["seq"] != "1" || msg.ContentType != "text/plain" {
+				t.Fatalf("消息错误: %+v", msg)
+			}
+			counts["all"]++
+		case <-g1:
+			counts["g"]++
+		case <-g2:
+			counts["g"]++
+		case <-timeout:
+			t.Fatalf("消息数量错误: %v", counts)
+		}
+	}
+	select {
+	case msg := <-g1:
+		t.Fatalf("队列组重复收到消息: %+v", msg)
+	case <-g2:
+		t.Fatal("队列组重复收到消息")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNats_Request(t *testing.T) {
+	p := runNats(t)
+	defer p.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go p.Subscribe(ctx, "svc/echo", func(ctx context.Context, msg *mq.Message) error {
+		return p.Reply(msg, append([]byte("echo:"), msg.Payload...))
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	resp, err := p.Request(ctx, "svc/echo", []byte("hi"), mq.WithCorrelationID("c1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Payload) != "echo:hi" || resp.CorrelationID != "c1" {
+		t.Fatalf("回复错误: %+v", resp)
+	}
+	if _, err := p.Request(ctx, "svc/none", nil); err == nil {
+		t.Fatal("没有订阅者时应返回错误")
+	}
+}
+
+func TestNats_JetStream(t *testing.T) {
+	p := runNats(t)
+	p.Stream = "ORDERS"
+	p.StreamSubjects = []string{"orders/#"}
+	defer p.Close()
+
+	// 订阅前发送的消息保存在流中, 持久消费者从头接收
+	for _, id := range []string{"1", "2", "2"} {
+		if err := p.Publish("orders/created", []byte(id), mq.WithMessageID(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := subscribe(t, ctx, p, "orders/#", mq.WithShareGroup("billing"))
+	for _, want := range []string{"1", "2"} {
+		select {
+		case msg := <-ch:
+			if string(msg.Payload) != want || msg.Topic != "orders/created" {
+				t.Fatalf("消息错误: %+v", msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("未收到消息%s", want)
+		}
+	}
+	select {
+	case msg := <-ch:
+		t.Fatalf("重复的消息ID未去重: %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNats_JetStreamMaxDeliver(t *testing.T) {
+	p := runNats(t)
+	p.Stream = "TASKS"
+	p.StreamSubjects = []string{"tasks/#"}
+	p.MaxDeliver = 3
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan time.Time, 8)
+	go p.Subscribe(ctx, "tasks/#", func(ctx context.Context, msg *mq.Message) error {
+		ch <- time.Now()
+		return errors.New("处理失败")
+	}, mq.WithShareGroup("worker"))
+	time.Sleep(100 * time.Millisecond)
+	if err := p.Publish("tasks/1", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	// 总是失败的消息按加倍的延迟重新投递, 达到 MaxDeliver 后不再投递
+	var times []time.Time
+	for i := 0; i < p.MaxDeliver; i++ {
+		select {
+		case at := <-ch:
+			times = append(times, at)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("第%d次投递超时", i+1)
+		}
+	}
+	if d := times[2].Sub(times[1]); d < 150*time.Millisecond {
+		t.Fatalf("第3次投递间隔%v, 未按加倍的延迟重新投递", d)
+	}
+	select {
+	case <-ch:
+		t.Fatal("超过 MaxDeliver 后仍重新投递")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestNats_Subject(t *testing.T) {
+	p := runNats(t)
+	defer p.Close()
+	// 含 . 的 topic 会被拆分为多级, 订阅者收到 a/b/c, 因此拒绝
+	if err := p.Publish("a.b/c", []byte("x")); err == nil {
+		t.Fatal("topic 包含 . 时 Publish 应返回错误")
+	}
+	if err := p.Subscribe(context.Background(), "a.b/#", nil); err == nil {
+		t.Fatal("订阅包含 . 的 topic 时应返回错误")
+	}
+}
+
+func TestNats_JetStreamRedeliver(t *testing.T) {
+	p := runNats(t)
+	p.Stream = "JOBS"
+	p.StreamSubjects = []string{"jobs/#"}
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan *mq.Message, 4)
+	var failed bool
+	go p.Subscribe(ctx, "jobs/#", func(ctx context.Context, msg *mq.Message) error {
+		ch <- msg
+		if !failed {
+			failed = true
+			return errors.New("处理失败")
+		}
+		return nil
+	}, mq.WithShareGroup("worker"))
+	time.Sleep(100 * time.Millisecond)
+	if err := p.Publish("jobs/1", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	// 处理失败的消息重新投递, 成功后不再投递
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-ch:
+			if string(msg.Payload) != "x" {
+				t.Fatalf("消息错误: %+v", msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("第%d次投递超时", i+1)
+		}
+	}
+	select {
+	case msg := <-ch:
+		t.Fatalf("确认后的消息被重新投递: %+v", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
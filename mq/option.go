@@ -0,0 +1,148 @@
+package mq
+
+import "time"
+
+// PublishOption is 单条消息的发送选项.
+// 标注 MQTT 3.1 不支持的选项在 MQTT 3.1/3.1.1 连接上发送时被忽略, 不返回错误,
+// 以便同一组选项可用于不同的 broker, 需要这些属性时应使用 ProtocolVersion 5.
+type PublishOption func(*publishOptions)
+
+// publishOptions 汇总单条消息的发送选项.
+type publishOptions struct {
+	qos       byte
+	retain    bool
+	topicVars interface{}
+	headers   map[string]string
+	expiry    time.Duration
+	expirySet bool
+
+	contentType     string
+	contentEncoding string
+	persistent      bool
+	priority        uint8
+	correlationID   string
+	replyTo         string
+	messageID       string
+	timestamp       time.Time
+	exchange        string
+}
+
+// WithQos is 设置消息的 QoS 等级.
+func WithQos(qos byte) PublishOption {
+	return func(o *publishOptions) {
+		o.qos = qos
+	}
+}
+
+// WithRetain is 设置消息为保留消息, 新的订阅者会立即收到该 topic 最后一条保留消息.
+func WithRetain(retain bool) PublishOption {
+	return func(o *publishOptions) {
+		o.retain = retain
+	}
+}
+
+// WithTopicVars is 设置 topic 模板变量, vars 可以是 map 或结构体.
+// 未设置时使用消息本身的字段填充模板.
+func WithTopicVars(vars interface{}) PublishOption {
+	return func(o *publishOptions) {
+		o.topicVars = vars
+	}
+}
+
+// WithHeader is 设置消息头, MQTT 5 中对应用户属性, MQTT 3.1 不支持.
+func WithHeader(key, value string) PublishOption {
+	return func(o *publishOptions) {
+		if o.headers == nil {
+			o.headers = make(map[string]string)
+		}
+		o.headers[key] = value
+	}
+}
+
+// WithExpiry is 设置消息有效期, 超时未投递的消息被 broker 丢弃, MQTT 3.1 不支持, 消息不会过期.
+// MQTT 5 中有效期按秒取整, 不足 1 秒按 1 秒. RabbitMQ 默认有效期为 60 秒, WithExpiry(0) 表示不过期.
+func WithExpiry(d time.Duration) PublishOption {
+	return func(o *publishOptions) {
+		o.expiry = d
+		o.expirySet = true
+	}
+}
+
+// WithContentType is 设置消息内容类型, MQTT 3.1 不支持.
+func WithContentType(contentType string) PublishOption {
+	return func(o *publishOptions) {
+		o.contentType = contentType
+	}
+}
+
+// WithPersistent is 设置消息持久化, broker 重启后消息不丢失, 仅 RabbitMQ 支持.
+func WithPersistent(persistent bool) PublishOption {
+	return func(o *publishOptions) {
+		o.persistent = persistent
+	}
+}
+
+// WithPriority is 设置消息优先级 0-9, 队列需声明 x-max-priority, 仅 RabbitMQ 支持.
+func WithPriority(priority uint8) PublishOption {
+	return func(o *publishOptions) {
+		o.priority = priority
+	}
+}
+
+// WithCorrelationID is 设置关联ID, 用于关联请求和响应, MQTT 3.1 不支持.
+func WithCorrelationID(id string) PublishOption {
+	return func(o *publishOptions) {
+		o.correlationID = id
+	}
+}
+
+// WithReplyTo is 设置响应的 topic 或队列, MQTT 3.1 不支持.
+func WithReplyTo(replyTo string) PublishOption {
+	return func(o *publishOptions) {
+		o.replyTo = replyTo
+	}
+}
+
+// WithMessageID is 设置消息ID, 订阅者从消息头 HeaderMessageID 读取, MQTT 3.1 不支持.
+// RabbitMQ 中对应 message-id 属性, NATS 中对应 Nats-Msg-Id 消息头, 可用于 JetStream 去重.
+func WithMessageID(id string) PublishOption {
+	return func(o *publishOptions) {
+		o.messageID = id
+	}
+}
+
+// WithExchange is 设置发送的 exchange, 仅 RabbitMQ 的 Publish、PublishKey 支持,
+// 为空时使用配置的 Exchange. 该 exchange 不会被声明, 需已存在.
+func WithExchange(exchange string) PublishOption {
+	return func(o *publishOptions) {
+		o.exchange = exchange
+	}
+}
+
+// messageHeaders 返回消息头, 设置了消息ID时包含 HeaderMessageID.
+func (o *publishOptions) messageHeaders() map[string]string {
+	if o.messageID == "" {
+		return o.headers
+	}
+	headers := make(map[string]string, len(o.headers)+1)
+	for k, v := range o.headers {
+		headers[k] = v
+	}
+	headers[HeaderMessageID] = o.messageID
+	return headers
+}
+
+// WithTimestamp is 设置消息时间戳, 仅 RabbitMQ 支持.
+func WithTimestamp(t time.Time) PublishOption {
+	return func(o *publishOptions) {
+		o.timestamp = t
+	}
+}
+
+func newPublishOptions(opts []PublishOption) *publishOptions {
+	o := &publishOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
@@ -0,0 +1,247 @@
+package mq
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// defaultOutboxTable 为发件箱默认表名.
+const defaultOutboxTable = "mq_outbox"
+
+// SQLDialect is 发件箱使用的数据库方言, db.Dialect 实现该接口.
+type SQLDialect interface {
+	// Rebind 将使用 ? 占位符的语句转换为数据库的占位符语法.
+	Rebind(query string) string
+	// Limit 为语句追加限制返回行数为 n 的子句.
+	Limit(query string, n int) string
+}
+
+// Outbox is 事务性发件箱, 在业务数据所在的事务中写入待发送的消息, 由 OutboxRelay 发送,
+// 避免写入数据后、发送消息前进程崩溃导致消息丢失. 语句只使用标准 SQL 及 LIMIT, 占位符不是 ?
+// 或不支持 LIMIT 的数据库(如 Oracle)需设置 Dialect. 表结构如下(MySQL/MariaDB, SQLite 中 id 为
+// INTEGER PRIMARY KEY AUTOINCREMENT, payload 为 BLOB; Oracle 中 id 为 NUMBER GENERATED BY DEFAULT AS IDENTITY,
+// payload 为 BLOB, headers 为 CLOB, 时间为 TIMESTAMP):
+//
+//	CREATE TABLE mq_outbox (
+//		id             BIGINT AUTO_INCREMENT PRIMARY KEY,
+//		topic          VARCHAR(255) NOT NULL,
+//		payload        LONGBLOB,
+//		qos            TINYINT NOT NULL DEFAULT 0,
+//		retained       TINYINT NOT NULL DEFAULT 0,
+//		content_type   VARCHAR(255),
+//		correlation_id VARCHAR(255),
+//		reply_to       VARCHAR(255),
+//		message_id     VARCHAR(255),
+//		headers        TEXT,
+//		created_at     DATETIME NOT NULL,
+//		sent_at        DATETIME NULL,
+//		INDEX idx_mq_outbox_sent (sent_at, id)
+//	);
+type Outbox struct {
+	Table string `json:"table" toml:"table" description:"发件箱表名,默认mq_outbox"`
+
+	// Dialect 不为空时按方言转换占位符及限制行数, 如 db.Oracle 的 Dialect(); 为空时使用 ? 及 LIMIT.
+	Dialect SQLDialect `json:"-" toml:"-"`
+}
+
+func (o *Outbox) table() string {
+	if o.Table == "" {
+		return defaultOutboxTable
+	}
+	return o.Table
+}
+
+// query 返回使用 Dialect 占位符的语句, %s 替换为表名.
+func (o *Outbox) query(format string) string {
+	query := fmt.Sprintf(format, o.table())
+	if o.Dialect != nil {
+		return o.Dialect.Rebind(query)
+	}
+	return query
+}
+
+// limit 返回限制 query 返回行数为 n 的语句.
+func (o *Outbox) limit(query string, n int) string {
+	if o.Dialect != nil {
+		return o.Dialect.Limit(query, n)
+	}
+	return query + " LIMIT " + strconv.Itoa(n)
+}
+
+// Publish is 在 tx 中写入一条待发送的消息, 事务提交后由 OutboxRelay 发送, 回滚时不发送.
+// 支持 WithQos、WithRetain、WithHeader、WithContentType、WithCorrelationID、WithReplyTo、WithMessageID,
+// 未指定 WithMessageID 时消息ID为 outbox-<id>, 消费者可据此去重.
+func (o *Outbox) Publish(tx *sql.Tx, topic string, payload []byte, opts ...PublishOption) error {
+	po := newPublishOptions(opts)
+	var headers interface{}
+	if len(po.headers) > 0 {
+		b, err := json.Marshal(po.headers)
+		if err != nil {
+			return err
+		}
+		headers = string(b)
+	}
+	_, err := tx.Exec(o.query("INSERT INTO %s(topic,payload,qos,retained,content_type,correlation_id,reply_to,message_id,headers,created_at) VALUES(?,?,?,?,?,?,?,?,?,?)"),
+		topic, payload, po.qos, po.retain, nullString(po.contentType), nullString(po.correlationID),
+		nullString(po.replyTo), nullString(po.messageID), headers, time.Now())
+	if err != nil {
+		return fmt.Errorf("发件箱写入失败.%v", err)
+	}
+	return nil
+}
+
+// nullString 将空字符串转为 NULL.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// outboxRow 为发件箱中的一条消息.
+type outboxRow struct {
+	id            int64
+	topic         string
+	payload       []byte
+	qos           byte
+	retained      bool
+	contentType   sql.NullString
+	correlationID sql.NullString
+	replyTo       sql.NullString
+	messageID     sql.NullString
+	headers       sql.NullString
+}
+
+// options 返回发送该消息的选项, RabbitMQ 中消息持久化且不过期.
+func (r *outboxRow) options() ([]PublishOption, error) {
+	id := r.messageID.String
+	if id == "" {
+		id = "outbox-" + strconv.FormatInt(r.id, 10)
+	}
+	opts := []PublishOption{
+		WithQos(r.qos),
+		WithRetain(r.retained),
+		WithContentType(r.contentType.String),
+		WithCorrelationID(r.correlationID.String),
+		WithReplyTo(r.replyTo.String),
+		WithMessageID(id),
+		WithPersistent(true),
+		WithExpiry(0),
+	}
+	if r.headers.String != "" {
+		var headers map[string]string
+		if err := json.Unmarshal([]byte(r.headers.String), &headers); err != nil {
+			return nil, fmt.Errorf("发件箱消息%d的消息头错误.%v", r.id, err)
+		}
+		for k, v := range headers {
+			opts = append(opts, WithHeader(k, v))
+		}
+	}
+	return opts, nil
+}
+
+// OutboxRelay is 发件箱中继, 按写入顺序读取未发送的消息, 通过 Publisher(如 Emqtt、RabbitMQ)
+// 发送成功后标记为已发送. 发送失败时停止本轮并在下一轮从失败的消息重试, 因此同一发件箱内消息按顺序发送.
+// 标记失败或进程在发送后、标记前崩溃时消息会被重复发送, 即至少发送一次.
+// 同一张表只应运行一个中继, 多个中继会重复发送.
+type OutboxRelay struct {
+	DB        *sql.DB
+	Outbox    *Outbox
+	Publisher Publisher
+
+	// BatchSize 为每轮读取的最大消息数, 默认 100.
+	BatchSize int
+	// Interval 为没有待发送消息或发送失败时的轮询间隔, 默认 1 秒.
+	Interval time.Duration
+	// OnError 不为空时在发送或读写发件箱失败时调用, 用于记录日志.
+	OnError func(err error)
+}
+
+// NewOutboxRelay is 创建发件箱中继.
+func NewOutboxRelay(db *sql.DB, outbox *Outbox, pub Publisher) *OutboxRelay {
+	return &OutboxRelay{DB: db, Outbox: outbox, Publisher: pub}
+}
+
+// Run is 循环发送发件箱中的消息, 阻塞直到 ctx 结束.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		n, err := r.Relay(ctx)
+		if err != nil && r.OnError != nil {
+			r.OnError(err)
+		}
+		if err == nil && n > 0 {
+			// 可能还有待发送的消息, 立即进行下一轮
+			if ctx.Err() != nil {
+				return nil
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Relay is 发送一批未发送的消息, 返回发送成功的数量, 遇到错误时返回已发送的数量和该错误.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	rows, err := r.pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+	update := r.Outbox.query("UPDATE %s SET sent_at=? WHERE id=?")
+	for i, row := range rows {
+		if ctx.Err() != nil {
+			return i, nil
+		}
+		opts, err := row.options()
+		if err != nil {
+			return i, err
+		}
+		if err := r.Publisher.Publish(row.topic, row.payload, opts...); err != nil {
+			return i, fmt.Errorf("发件箱消息%d发送失败.%v", row.id, err)
+		}
+		_, err = r.DB.ExecContext(ctx, update, time.Now(), row.id)
+		if err != nil {
+			return i, fmt.Errorf("发件箱消息%d标记失败.%v", row.id, err)
+		}
+	}
+	return len(rows), nil
+}
+
+// pending 按写入顺序读取最多 BatchSize 条未发送的消息.
+func (r *OutboxRelay) pending(ctx context.Context) ([]*outboxRow, error) {
+	limit := r.BatchSize
+	if limit <= 0 {
+		limit = 100
+	}
+	query := r.Outbox.limit(r.Outbox.query("SELECT id,topic,payload,qos,retained,content_type,correlation_id,reply_to,message_id,headers FROM %s WHERE sent_at IS NULL ORDER BY id"), limit)
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("发件箱读取失败.%v", err)
+	}
+	defer rows.Close()
+	var list []*outboxRow
+	for rows.Next() {
+		row := &outboxRow{}
+		err := rows.Scan(&row.id, &row.topic, &row.payload, &row.qos, &row.retained, &row.contentType,
+			&row.correlationID, &row.replyTo, &row.messageID, &row.headers)
+		if err != nil {
+			return nil, fmt.Errorf("发件箱读取失败.%v", err)
+		}
+		list = append(list, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("发件箱读取失败.%v", err)
+	}
+	return list, nil
+}
@@ -0,0 +1,194 @@
+package mq_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/zhgqiang/commongo/data"
+	"github.com/zhgqiang/commongo/mq"
+	"github.com/zhgqiang/commongo/utils"
+)
+
+const outboxSchema = `
+CREATE TABLE orders (id INTEGER PRIMARY KEY, amount INTEGER);
+CREATE TABLE mq_outbox (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	topic          VARCHAR(255) NOT NULL,
+	payload        BLOB,
+	qos            TINYINT NOT NULL DEFAULT 0,
+	retained       TINYINT NOT NULL DEFAULT 0,
+	content_type   VARCHAR(255),
+	correlation_id VARCHAR(255),
+	reply_to       VARCHAR(255),
+	message_id     VARCHAR(255),
+	headers        TEXT,
+	created_at     DATETIME NOT NULL,
+	sent_at        DATETIME NULL
+);`
+
+// failingPublisher 发送失败直到 fail 为 false.
+type failingPublisher struct {
+	mq.Publisher
+	fail bool
+}
+
+func (p *failingPublisher) Publish(topic string, payload []byte, opts ...mq.PublishOption) error {
+	if p.fail {
+		return errors.New("broker不可用")
+	}
+	return p.Publisher.Publish(topic, payload, opts...)
+}
+
+func TestOutboxRelay(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(outboxSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := &mq.Outbox{}
+	insert := func(id int, commit bool) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, _ := data.NewJSON(map[string]interface{}{"id": id, "amount": 10})
+		if err := utils.SQLInsertDataTx(tx, d, "orders", false, nil, 0); err != nil {
+			t.Fatal(err)
+		}
+		err = outbox.Publish(tx, "orders/created", d, mq.WithContentType("application/json"), mq.WithHeader("source", "test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert(1, true)
+	insert(2, false)
+	insert(3, true)
+
+	b := mq.NewMemoryBroker()
+	defer b.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := subscribe(t, ctx, b, "orders/#")
+	time.Sleep(20 * time.Millisecond)
+
+	pub := &failingPublisher{Publisher: b, fail: true}
+	relay := mq.NewOutboxRelay(db, outbox, pub)
+	if n, err := relay.Relay(ctx); err == nil || n != 0 {
+		t.Fatalf("发送失败时应返回错误: %d, %v", n, err)
+	}
+	pub.fail = false
+	if n, err := relay.Relay(ctx); err != nil || n != 2 {
+		t.Fatalf("Relay = %d, %v, 期望发送2条", n, err)
+	}
+	for _, want := range []float64{1, 3} {
+		select {
+		case msg := <-ch:
+			var m map[string]interface{}
+			json.Unmarshal(msg.Payload, &m)
+			if m["id"] != want || msg.ContentType != "application/json" || msg.Headers["source"] != "test" {
+				t.Fatalf("消息错误: %+v", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("未收到消息")
+		}
+	}
+	if n, err := relay.Relay(ctx); err != nil || n != 0 {
+		t.Fatalf("已发送的消息不应重复发送: %d, %v", n, err)
+	}
+	var orders int
+	db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&orders)
+	if orders != 2 {
+		t.Fatalf("回滚的数据不应写入: %d", orders)
+	}
+}
+
+// numberedDialect 将 ? 转换为 ?1、?2, 记录转换的语句数及限制的行数.
+type numberedDialect struct {
+	n      int
+	limits []int
+}
+
+func (d *numberedDialect) Limit(query string, n int) string {
+	d.limits = append(d.limits, n)
+	return query + " LIMIT " + strconv.Itoa(n)
+}
+
+func (d *numberedDialect) Rebind(query string) string {
+	d.n++
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			fmt.Fprintf(&b, "?%d", n)
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func TestOutboxRelay_Dialect(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(outboxSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	dialect := &numberedDialect{}
+	outbox := &mq.Outbox{Dialect: dialect}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := outbox.Publish(tx, "orders/created", []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := mq.NewMemoryBroker()
+	defer b.Close()
+	relay := mq.NewOutboxRelay(db, outbox, b)
+	relay.BatchSize = 2
+	for _, want := range []int{2, 1, 0} {
+		if n, err := relay.Relay(context.Background()); err != nil || n != want {
+			t.Fatalf("Relay = %d, %v, 期望发送%d条", n, err, want)
+		}
+	}
+	if dialect.n == 0 {
+		t.Fatal("未按 Dialect 转换占位符")
+	}
+	if len(dialect.limits) != 3 || dialect.limits[0] != 2 {
+		t.Fatalf("未按 Dialect 限制行数, %v", dialect.limits)
+	}
+}
@@ -0,0 +1,31 @@
+package mq
+
+import "context"
+
+// Publisher is 与 broker 无关的消息发送接口.
+// topic 使用 MQTT 风格的 / 分隔, RabbitMQ 中转换为 . 分隔的 routing key,
+// 因此 RabbitMQ 的 topic 不能包含 ., 各 broker 的订阅者收到的 Topic 与发送时一致.
+type Publisher interface {
+	Publish(topic string, payload []byte, opts ...PublishOption) error
+}
+
+// Subscriber is 与 broker 无关的消息订阅接口, 阻塞直到 ctx 结束或连接断开.
+// topic 可以使用 MQTT 通配符 + 和 #, RabbitMQ 中转换为绑定键的 * 和 #.
+// WithShareGroup 的多个订阅者分摊消息, 否则每个订阅者都收到全部消息.
+type Subscriber interface {
+	Subscribe(ctx context.Context, topic string, h Handler, opts ...SubscribeOption) error
+}
+
+// PubSub is 同时支持发送和订阅的 broker.
+type PubSub interface {
+	Publisher
+	Subscriber
+}
+
+var (
+	_ PubSub = (*Emqtt)(nil)
+	_ PubSub = (*RabbitMQ)(nil)
+	_ PubSub = (*MemoryBroker)(nil)
+	_ PubSub = (*Nats)(nil)
+	_ PubSub = (*RabbitPublisher)(nil)
+)
@@ -2,6 +2,9 @@ package mq
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/streadway/amqp"
 )
@@ -16,21 +19,158 @@ type RabbitMQ struct {
 	VHost      string `json:"vHost" toml:"vHost" description:"消息队列VHost名"`
 	Exchange   string `json:"exchange" toml:"exchange" description:"消息队列Exchange名"`
 	RoutingKey string `json:"routingKey" toml:"routingKey" description:"消息队列名"`
+
+	Codec       string `json:"codec" toml:"codec" description:"消息编码:json、msgpack、cbor、protobuf,默认json"`
+	Compression string `json:"compression" toml:"compression" description:"消息压缩:gzip、zstd,默认不压缩"`
+
+	ChannelPoolSize int  `json:"channelPoolSize" toml:"channelPoolSize" description:"长连接发送者的管道池大小,默认8"`
+	Timeout         int  `json:"timeout" toml:"timeout" description:"等待空闲管道及发送确认的超时时间,单位秒,默认10"`
+	Confirm         bool `json:"confirm" toml:"confirm" description:"是否开启发送确认,开启后broker确认才返回"`
+	Mandatory       bool `json:"mandatory" toml:"mandatory" description:"无法路由的消息是否退回,开启时自动开启发送确认"`
+	DelayedExchange bool `json:"delayedExchange" toml:"delayedExchange" description:"延迟消息是否使用rabbitmq_delayed_message_exchange插件"`
+
+	Topology *Topology `json:"topology" toml:"topology" description:"启动时声明的exchange、队列及绑定"`
+
+	// Recorder 不为空时录制发送的消息, topic 为 RoutingKey.
+	Recorder *Recorder `json:"-" toml:"-"`
 }
 
-// Send is RabbitMQ 发送信息.
-func (p *RabbitMQ) Send(msg string) error {
-	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:%d/%s", p.Username, p.Password, p.Host, p.Port, p.VHost))
+// defaultRabbitExpiration 为消息默认有效期.
+const defaultRabbitExpiration = "60000"
+
+// Send is RabbitMQ 发送信息, 默认 ContentType 为 text/plain.
+func (p *RabbitMQ) Send(msg string, opts ...PublishOption) error {
+	return p.publish(p.RoutingKey, p.publishing([]byte(msg), newPublishOptions(textOptions(opts))))
+}
+
+// SendValue is RabbitMQ 按 Codec 编码、Compression 压缩发送 val,
+// ContentType 和 ContentEncoding 随消息发送, 消费者可使用 DecodePayload 自动解码.
+func (p *RabbitMQ) SendValue(val interface{}, opts ...PublishOption) error {
+	o := newPublishOptions(opts)
+	body, err := p.encode(val, o)
 	if err != nil {
-		return fmt.Errorf("RabbitMQ连接失败.%v", err)
+		return err
 	}
-	defer conn.Close()
-	ch, err := conn.Channel()
+	return p.publish(p.RoutingKey, p.publishing(body, o))
+}
+
+// Publish is RabbitMQ 向 topic 发送原始数据, 实现 Publisher, routing key 为 RoutingKey(topic),
+// 即 a/b/c 转换为 a.b.c, 订阅者收到的 Topic 仍为 a/b/c, 与其他 broker 一致.
+// topic 中不能包含 ., 否则返回错误: . 在 routing key 中是层级分隔符, 订阅者收到的 Topic 会变为 / 分隔.
+// 使用 routing key 发送请用 PublishKey.
+// 消息默认不持久化、有效期 60 秒, 可通过 WithPersistent、WithExpiry 等选项设置消息属性.
+func (p *RabbitMQ) Publish(topic string, payload []byte, opts ...PublishOption) error {
+	key, err := topicKey(topic)
+	if err != nil {
+		return err
+	}
+	return p.PublishKey(key, payload, opts...)
+}
+
+// PublishKey is RabbitMQ 使用 key 作为 routing key 发送原始数据, 消息属性同 Publish.
+func (p *RabbitMQ) PublishKey(key string, payload []byte, opts ...PublishOption) error {
+	o := newPublishOptions(opts)
+	return p.publishDelayed(o.exchange, key, p.publishing(payload, o), 0)
+}
+
+// topicKey 将 topic 转换为 routing key, topic 中包含 . 时返回 Permanent 错误.
+func topicKey(topic string) (string, error) {
+	if strings.Contains(topic, ".") {
+		return "", Permanent(fmt.Errorf("RabbitMQ的topic %s不能包含.,.是routing key的层级分隔符", topic))
+	}
+	return RoutingKey(topic), nil
+}
+
+// textOptions 在 opts 前添加 text/plain 内容类型.
+func textOptions(opts []PublishOption) []PublishOption {
+	return append([]PublishOption{WithContentType("text/plain")}, opts...)
+}
+
+// encode 按 Codec、Compression 编码 val, 并设置 o 的内容类型和压缩方式.
+func (p *RabbitMQ) encode(val interface{}, o *publishOptions) ([]byte, error) {
+	codec, err := GetCodec(p.Codec)
+	if err != nil {
+		return nil, err
+	}
+	body, err := EncodePayload(codec, p.Compression, val)
+	if err != nil {
+		return nil, err
+	}
+	o.contentType = codec.ContentType()
+	o.contentEncoding = p.Compression
+	return body, nil
+}
+
+// publishing 按发送选项生成 AMQP 消息.
+func (p *RabbitMQ) publishing(body []byte, o *publishOptions) amqp.Publishing {
+	msg := amqp.Publishing{
+		ContentType:     o.contentType,
+		ContentEncoding: o.contentEncoding,
+		Expiration:      defaultRabbitExpiration,
+		Priority:        o.priority,
+		CorrelationId:   o.correlationID,
+		ReplyTo:         o.replyTo,
+		MessageId:       o.messageID,
+		Timestamp:       o.timestamp,
+		Body:            body,
+	}
+	if o.expirySet {
+		msg.Expiration = ""
+		if o.expiry > 0 {
+			msg.Expiration = strconv.FormatInt(o.expiry.Milliseconds(), 10)
+		}
+	}
+	if o.persistent {
+		msg.DeliveryMode = amqp.Persistent
+	}
+	// 其他 broker 以消息头传递的属性转换为 AMQP 消息属性
+	for k, v := range o.headers {
+		switch {
+		case k == HeaderContentEncoding && msg.ContentEncoding == "":
+			msg.ContentEncoding = v
+		case k == HeaderMessageID && msg.MessageId == "":
+			msg.MessageId = v
+		default:
+			if msg.Headers == nil {
+				msg.Headers = make(amqp.Table, len(o.headers))
+			}
+			msg.Headers[k] = v
+		}
+	}
+	return msg
+}
+
+// url 返回 AMQP 连接地址.
+func (p *RabbitMQ) url() string {
+	return fmt.Sprintf("amqp://%s:%s@%s:%d/%s", p.Username, p.Password, p.Host, p.Port, p.VHost)
+}
+
+// dial 建立 AMQP 连接.
+func (p *RabbitMQ) dial() (*amqp.Connection, error) {
+	conn, err := amqp.Dial(p.url())
 	if err != nil {
-		return fmt.Errorf("RabbitMQ管道打开失败.%v", err)
+		return nil, fmt.Errorf("RabbitMQ连接失败.%v", err)
+	}
+	return conn, nil
+}
+
+// declare 声明 Topology 及配置的 exchange, Topology 中已包含 Exchange 时按 Topology 声明.
+// 每次新建连接时调用, broker 重启丢失非持久化的队列和绑定后重新声明.
+func (p *RabbitMQ) declare(ch *amqp.Channel) error {
+	if p.Topology != nil {
+		if err := p.Topology.apply(ch); err != nil {
+			return err
+		}
+	}
+	return p.declareExchange(ch)
+}
+
+// declareExchange 声明配置的 exchange.
+func (p *RabbitMQ) declareExchange(ch *amqp.Channel) error {
+	if p.Topology.hasExchange(p.Exchange) {
+		return nil
 	}
-	defer ch.Close()
-	err = ch.ExchangeDeclare(
+	err := ch.ExchangeDeclare(
 		p.Exchange, // name
 		p.Kind,     // type
 		false,      // durable
@@ -42,18 +182,69 @@ func (p *RabbitMQ) Send(msg string) error {
 	if err != nil {
 		return fmt.Errorf("RabbitMQ的exchange定义失败.%v", err)
 	}
-	err = ch.Publish(
-		p.Exchange,   // exchange
-		p.RoutingKey, // routing key
-		false,        // mandatory
-		false,        // immediate
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Expiration:  "60000",
-			Body:        []byte(msg),
-		})
+	return nil
+}
+
+// confirm returns true if 需要等待 broker 确认, 开启 Mandatory 时需要确认才能得知消息是否被退回.
+func (p *RabbitMQ) confirm() bool {
+	return p.Confirm || p.Mandatory
+}
+
+func (p *RabbitMQ) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return defaultRabbitTimeout * time.Second
+	}
+	return time.Duration(p.Timeout) * time.Second
+}
+
+// publish 向 key 发送消息, 开启 Confirm 时等待 broker 确认,
+// 消息被退回时返回 *ReturnedError, 被拒绝时返回 ErrNacked.
+func (p *RabbitMQ) publish(key string, msg amqp.Publishing) error {
+	return p.publishDelayed("", key, msg, 0)
+}
+
+// publishDelayed 向 exchange 发送 routing key 为 key 的消息, exchange 为空时为配置的 Exchange,
+// delay 大于 0 时经延迟 exchange 发送.
+func (p *RabbitMQ) publishDelayed(exchange, key string, msg amqp.Publishing, delay time.Duration) error {
+	if err := p.checkDelay(delay); err != nil {
+		return err
+	}
+	conn, err := p.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c, err := openChannel(conn, p.confirm())
 	if err != nil {
-		return fmt.Errorf("RabbitMQ发送消息失败.%v", err)
+		return err
 	}
+	defer c.ch.Close()
+	if err := p.declare(c.ch); err != nil {
+		return err
+	}
+	if exchange == "" {
+		exchange = p.Exchange
+	}
+	if delay > 0 {
+		if exchange, err = p.delayRoute(c.ch, &msg, delay); err != nil {
+			return err
+		}
+	}
+	if err := c.publish(exchange, key, p.mandatory(delay), msg, p.timeout()); err != nil {
+		return err
+	}
+	p.record(key, msg)
 	return nil
 }
+
+// record 录制发送的消息.
+func (p *RabbitMQ) record(key string, msg amqp.Publishing) {
+	if p.Recorder != nil {
+		p.Recorder.Record(Record{
+			Broker:    "rabbitmq",
+			Direction: DIRECTION_PUBLISH,
+			Topic:     key,
+			Payload:   msg.Body,
+		})
+	}
+}
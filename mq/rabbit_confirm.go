@@ -0,0 +1,79 @@
+package mq
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrNacked is broker 拒绝了消息, 通常由于 broker 内部错误或队列达到上限.
+var ErrNacked = errors.New("RabbitMQ拒绝了消息")
+
+// ReturnedError is mandatory 消息无法路由到任何队列时 broker 退回的错误.
+type ReturnedError struct {
+	Exchange   string
+	RoutingKey string
+	Code       uint16
+	Reason     string
+}
+
+func (e *ReturnedError) Error() string {
+	return fmt.Sprintf("RabbitMQ消息被退回.exchange=%s routingKey=%s %d %s", e.Exchange, e.RoutingKey, e.Code, e.Reason)
+}
+
+// amqpChannel 为打开的管道, 开启 confirm 模式时同时监听确认和退回.
+type amqpChannel struct {
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+// openChannel 打开管道, confirm 为 true 时开启 confirm 模式.
+func openChannel(conn *amqp.Connection, confirm bool) (*amqpChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("RabbitMQ管道打开失败.%v", err)
+	}
+	c := &amqpChannel{ch: ch}
+	if confirm {
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			return nil, fmt.Errorf("RabbitMQ开启confirm模式失败.%v", err)
+		}
+		c.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+		c.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	}
+	return c, nil
+}
+
+// publish 发送消息, confirm 模式下等待 broker 确认.
+// broker 先退回无法路由的消息再发送确认, 因此收到确认后检查是否有退回.
+func (c *amqpChannel) publish(exchange, key string, mandatory bool, msg amqp.Publishing, timeout time.Duration) error {
+	if err := c.ch.Publish(exchange, key, mandatory, false, msg); err != nil {
+		return fmt.Errorf("RabbitMQ发送消息失败.%v", err)
+	}
+	if c.confirms == nil {
+		return nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case conf, ok := <-c.confirms:
+		if !ok {
+			return errors.New("RabbitMQ发送消息失败.管道已关闭")
+		}
+		select {
+		case r := <-c.returns:
+			return &ReturnedError{Exchange: r.Exchange, RoutingKey: r.RoutingKey, Code: r.ReplyCode, Reason: r.ReplyText}
+		default:
+		}
+		if !conf.Ack {
+			return ErrNacked
+		}
+		return nil
+	case <-timer.C:
+		return errors.New("RabbitMQ等待发送确认超时")
+	}
+}
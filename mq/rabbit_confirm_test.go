@@ -0,0 +1,55 @@
+package mq
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRabbitMQ_Confirm(t *testing.T) {
+	p := rabbitFromEnv(t)
+	p.Confirm = true
+	p.Mandatory = true
+	rabbitQueue(t, p, "commongo.test.confirm", "confirm", nil)
+	// 队列已满时拒绝新消息, broker 返回 nack
+	rabbitQueue(t, p, "commongo.test.nack", "nack", amqp.Table{
+		"x-max-length": int32(0),
+		"x-overflow":   "reject-publish",
+	})
+
+	pub, err := p.NewPublisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	senders := []struct {
+		name string
+		send func(key string) error
+	}{
+		{"RabbitMQ", func(key string) error {
+			q := *p
+			q.RoutingKey = key
+			return q.Send("x")
+		}},
+		{"RabbitPublisher", func(key string) error {
+			return pub.publish(key, p.publishing([]byte("x"), newPublishOptions(nil)))
+		}},
+	}
+	for _, s := range senders {
+		if err := s.send("confirm"); err != nil {
+			t.Fatalf("%s: 确认的消息返回错误 %v", s.name, err)
+		}
+		if err := s.send("nack"); !errors.Is(err, ErrNacked) {
+			t.Fatalf("%s: 被拒绝的消息应返回 ErrNacked, 实际 %v", s.name, err)
+		}
+		var re *ReturnedError
+		if err := s.send("unrouted"); !errors.As(err, &re) || re.RoutingKey != "unrouted" || re.Code != amqp.NoRoute {
+			t.Fatalf("%s: 无法路由的消息应返回 *ReturnedError, 实际 %v", s.name, err)
+		}
+		// 退回之后管道仍可继续使用
+		if err := s.send("confirm"); err != nil {
+			t.Fatalf("%s: 退回后发送失败 %v", s.name, err)
+		}
+	}
+}
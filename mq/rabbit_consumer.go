@@ -0,0 +1,379 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// HeaderRetryCount is 消息已重试次数的消息头.
+const HeaderRetryCount = "x-retry-count"
+
+// HeaderRoutingKey is 重试或进入死信的消息记录原 routing key 的消息头.
+// 重新发送后消息的 routing key 变为队列名, 收到消息的 Topic 仍取原 routing key.
+const HeaderRoutingKey = "x-original-routing-key"
+
+// HeaderDeadReason is 进入死信 exchange 的消息记录处理错误的消息头.
+const HeaderDeadReason = "x-dead-reason"
+
+// ConsumeOption is RabbitMQ 消费选项.
+type ConsumeOption func(*consumeOptions)
+
+// consumeOptions 汇总消费选项.
+type consumeOptions struct {
+	prefetch    int
+	concurrency int
+	maxRetries  int
+	retryDelay  time.Duration
+	deadLetter  string
+
+	middlewares []Middleware
+}
+
+// WithPrefetch is 设置未确认消息的最大数量, 默认为并发数.
+func WithPrefetch(n int) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.prefetch = n
+	}
+}
+
+// WithConcurrency is 设置处理消息的协程数, 默认为 1.
+func WithConcurrency(n int) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.concurrency = n
+	}
+}
+
+// WithRetry is 设置处理失败后的最大重试次数及重试间隔.
+// 失败的消息发送到 <queue>.retry 队列, 等待 delay 后由 broker 转回原队列.
+func WithRetry(max int, delay time.Duration) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.maxRetries = max
+		o.retryDelay = delay
+	}
+}
+
+// WithDeadLetter is 设置死信 exchange, 重试耗尽或返回 Permanent 错误的消息发送到该 exchange,
+// 并由 <queue>.dlq 队列接收.
+func WithDeadLetter(exchange string) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.deadLetter = exchange
+	}
+}
+
+func newConsumeOptions(opts []ConsumeOption) *consumeOptions {
+	o := &consumeOptions{concurrency: 1}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.concurrency < 1 {
+		o.concurrency = 1
+	}
+	if o.prefetch <= 0 {
+		o.prefetch = o.concurrency
+	}
+	return o
+}
+
+// permanentError 表示消息无法处理, 不再重试.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent is 包装 err, Handler 返回该错误时消息不再重试, 直接进入死信.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Consume is 消费 queue 中的消息, 阻塞直到 ctx 结束或连接断开.
+// queue 由 Topology 声明时使用 Topology 的参数和绑定; 否则声明为持久化队列,
+// 配置了 Exchange 时按 RoutingKey 绑定.
+// h 返回 nil 时确认消息; 返回错误时按 WithRetry 重试, 重试耗尽后发送到 WithDeadLetter
+// 配置的 exchange, 未配置时拒绝消息且不重新入队, 此时由队列自身的死信配置决定去向.
+func (p *RabbitMQ) Consume(ctx context.Context, queue string, h Handler, opts ...ConsumeOption) error {
+	o := newConsumeOptions(opts)
+	return p.consume(ctx, h, o, func(ch *amqp.Channel) (string, error) {
+		return queue, p.declareConsume(ch, queue, o)
+	})
+}
+
+// Subscribe is 订阅 topic, 实现 Subscriber, 阻塞直到 ctx 结束或连接断开.
+// topic 按 RoutingKey 转换为绑定键绑定到 Exchange, 不能包含 ., 使用通配符时 Exchange 类型需为 topic;
+// 收到消息的 Topic 为 TopicFromRoutingKey(routing key).
+// 未指定 WithShareGroup 时使用连接独占的临时队列, 每个订阅者都收到全部消息;
+// 指定时使用持久化队列 <Exchange>.<group>.<绑定键>, 同一分组的订阅者分摊消息.
+// WithSubscribeExchange 指定时绑定到该 exchange, 队列名中的 Exchange 也为该 exchange.
+// WithWorkers 设置并发数, 不支持 WithOrderKey. h 返回错误时拒绝消息且不重新入队.
+func (p *RabbitMQ) Subscribe(ctx context.Context, topic string, h Handler, opts ...SubscribeOption) error {
+	so := newSubscribeOptions(opts)
+	// 在中间件之前转换 Topic
+	toTopic := func(h Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			msg.Topic = TopicFromRoutingKey(msg.Topic)
+			return h(ctx, msg)
+		}
+	}
+	o := newConsumeOptions([]ConsumeOption{
+		WithConcurrency(so.workers),
+		WithConsumeMiddleware(toTopic),
+		WithConsumeMiddleware(so.middlewares...),
+	})
+	key, err := topicKey(topic)
+	if err != nil {
+		return err
+	}
+	exchange := so.exchange
+	if exchange == "" {
+		exchange = p.Exchange
+	}
+	return p.consume(ctx, h, o, func(ch *amqp.Channel) (string, error) {
+		if err := p.declare(ch); err != nil {
+			return "", err
+		}
+		var q amqp.Queue
+		var err error
+		if so.group == "" {
+			q, err = ch.QueueDeclare("", false, true, true, false, nil)
+		} else {
+			q, err = ch.QueueDeclare(exchange+"."+so.group+"."+key, true, false, false, false, nil)
+		}
+		if err != nil {
+			return "", fmt.Errorf("RabbitMQ的订阅队列定义失败.%v", err)
+		}
+		if err := ch.QueueBind(q.Name, key, exchange, false, nil); err != nil {
+			return "", fmt.Errorf("RabbitMQ的订阅队列绑定失败.%v", err)
+		}
+		return q.Name, nil
+	})
+}
+
+// consume 使用 declare 声明并返回要消费的队列, 然后按 o 消费.
+func (p *RabbitMQ) consume(ctx context.Context, h Handler, o *consumeOptions, declare func(ch *amqp.Channel) (string, error)) error {
+	conn, err := p.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("RabbitMQ管道打开失败.%v", err)
+	}
+	defer ch.Close()
+	queue, err := declare(ch)
+	if err != nil {
+		return err
+	}
+	if err := ch.Qos(o.prefetch, 0, false); err != nil {
+		return fmt.Errorf("RabbitMQ设置prefetch失败.%v", err)
+	}
+	deliveries, err := ch.Consume(queue, "", false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("RabbitMQ消费队列%s失败.%v", queue, err)
+	}
+	h = Chain(h, o.middlewares...)
+	if p.Recorder != nil {
+		h = p.Recorder.Handler("rabbitmq", h)
+	}
+
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	work := make(chan amqp.Delivery)
+	for i := 0; i < o.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case d := <-work:
+					p.handleDelivery(ctx, ch, queue, d, h, o)
+				}
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-closed:
+			return fmt.Errorf("RabbitMQ连接断开.%v", err)
+		case d, ok := <-deliveries:
+			if !ok {
+				return errors.New("RabbitMQ消费已取消")
+			}
+			select {
+			case work <- d:
+			case <-ctx.Done():
+				// 未处理的消息重新入队
+				d.Nack(false, true)
+				return nil
+			}
+		}
+	}
+}
+
+// declareConsume 声明消费队列以及重试、死信队列.
+// Topology 中声明的队列已带有 TTL、死信等参数及绑定, 不再按默认参数重复声明,
+// 否则参数不一致时 broker 返回 406 错误.
+func (p *RabbitMQ) declareConsume(ch *amqp.Channel, queue string, o *consumeOptions) error {
+	if p.Exchange != "" {
+		if err := p.declare(ch); err != nil {
+			return err
+		}
+	} else if p.Topology != nil {
+		if err := p.Topology.apply(ch); err != nil {
+			return err
+		}
+	}
+	if !p.Topology.hasQueue(queue) {
+		if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("RabbitMQ的队列%s定义失败.%v", queue, err)
+		}
+		if p.Exchange != "" {
+			if err := ch.QueueBind(queue, p.RoutingKey, p.Exchange, false, nil); err != nil {
+				return fmt.Errorf("RabbitMQ的队列%s绑定失败.%v", queue, err)
+			}
+		}
+	}
+	if o.maxRetries > 0 {
+		// 重试队列中的消息过期后经默认 exchange 回到原队列
+		_, err := ch.QueueDeclare(queue+".retry", true, false, false, false, amqp.Table{
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		})
+		if err != nil {
+			return fmt.Errorf("RabbitMQ的重试队列定义失败.%v", err)
+		}
+	}
+	if o.deadLetter != "" {
+		if err := ch.ExchangeDeclare(o.deadLetter, amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("RabbitMQ的死信exchange定义失败.%v", err)
+		}
+		if _, err := ch.QueueDeclare(queue+".dlq", true, false, false, false, nil); err != nil {
+			return fmt.Errorf("RabbitMQ的死信队列定义失败.%v", err)
+		}
+		if err := ch.QueueBind(queue+".dlq", queue, o.deadLetter, false, nil); err != nil {
+			return fmt.Errorf("RabbitMQ的死信队列绑定失败.%v", err)
+		}
+	}
+	return nil
+}
+
+// handleDelivery 处理一条消息并根据结果确认、重试或进入死信.
+func (p *RabbitMQ) handleDelivery(ctx context.Context, ch *amqp.Channel, queue string, d amqp.Delivery, h Handler, o *consumeOptions) {
+	err := h(ctx, deliveryMessage(d))
+	if err == nil {
+		d.Ack(false)
+		return
+	}
+	if ctx.Err() != nil {
+		// 停止消费导致的失败不计入重试, 重新入队
+		d.Nack(false, true)
+		return
+	}
+
+	retries := retryCount(d.Headers)
+	var perm *permanentError
+	if !errors.As(err, &perm) && retries < o.maxRetries {
+		msg := republish(d, HeaderRetryCount, int32(retries+1))
+		msg.Expiration = fmt.Sprint(o.retryDelay.Milliseconds())
+		if perr := ch.Publish("", queue+".retry", false, false, msg); perr != nil {
+			d.Nack(false, true)
+			return
+		}
+		d.Ack(false)
+		return
+	}
+	if o.deadLetter != "" {
+		msg := republish(d, HeaderDeadReason, err.Error())
+		if perr := ch.Publish(o.deadLetter, queue, false, false, msg); perr != nil {
+			d.Nack(false, true)
+			return
+		}
+		d.Ack(false)
+		return
+	}
+	d.Nack(false, false)
+}
+
+// republish 复制消息用于重新发送, 并设置消息头 key.
+func republish(d amqp.Delivery, key string, val interface{}) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	if _, ok := headers[HeaderRoutingKey]; !ok {
+		headers[HeaderRoutingKey] = d.RoutingKey
+	}
+	headers[key] = val
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
+
+// retryCount 返回消息已重试次数.
+func retryCount(headers amqp.Table) int {
+	switch n := headers[HeaderRetryCount].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+// deliveryMessage 将 AMQP 消息转换为 Message, Topic 为 routing key,
+// 重试过的消息为 HeaderRoutingKey 记录的原 routing key.
+func deliveryMessage(d amqp.Delivery) *Message {
+	topic := d.RoutingKey
+	if key, ok := d.Headers[HeaderRoutingKey].(string); ok {
+		topic = key
+	}
+	msg := &Message{
+		Topic:         topic,
+		Payload:       d.Body,
+		ContentType:   d.ContentType,
+		ResponseTopic: d.ReplyTo,
+		CorrelationID: d.CorrelationId,
+	}
+	if len(d.Headers) > 0 || d.ContentEncoding != "" || d.MessageId != "" {
+		msg.Headers = make(map[string]string, len(d.Headers)+2)
+		for k, v := range d.Headers {
+			msg.Headers[k] = fmt.Sprint(v)
+		}
+		if d.ContentEncoding != "" {
+			msg.Headers[HeaderContentEncoding] = d.ContentEncoding
+		}
+		if d.MessageId != "" {
+			msg.Headers[HeaderMessageID] = d.MessageId
+		}
+	}
+	return msg
+}
@@ -0,0 +1,78 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRabbitMQ_retryTopic(t *testing.T) {
+	d := amqp.Delivery{RoutingKey: "orders.created", Headers: amqp.Table{"tenant": "t1"}}
+	// 第一次重试发送到 <queue>.retry, 过期后以队列名为 routing key 回到原队列
+	msg := republish(d, HeaderRetryCount, int32(1))
+	d = amqp.Delivery{RoutingKey: "orders", Headers: msg.Headers}
+	msg = republish(d, HeaderRetryCount, int32(2))
+	d = amqp.Delivery{RoutingKey: "orders", Headers: msg.Headers}
+	got := deliveryMessage(d)
+	if got.Topic != "orders.created" {
+		t.Fatalf("重试后 Topic 为 %q, 期望原 routing key", got.Topic)
+	}
+	if got.Headers["tenant"] != "t1" || retryCount(d.Headers) != 2 {
+		t.Fatalf("重试后消息头错误: %v", d.Headers)
+	}
+}
+
+func TestRabbitMQ_ConsumeTopologyQueue(t *testing.T) {
+	p := rabbitFromEnv(t)
+	queue := "commongo.test.topology"
+	p.RoutingKey = "topology"
+	p.Topology = &Topology{
+		Queues:   []QueueConfig{{Name: queue, MessageTTL: 60000, MaxLength: 100}},
+		Bindings: []BindingConfig{{Queue: queue, Exchange: p.Exchange, RoutingKey: "topology"}},
+	}
+	defer func() {
+		if conn, err := p.dial(); err == nil {
+			if ch, err := conn.Channel(); err == nil {
+				ch.QueueDelete(queue, false, false, false)
+				ch.QueueDelete(queue+".retry", false, false, false)
+			}
+			conn.Close()
+		}
+	}()
+	if err := p.DeclareTopology(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	got := make(chan *Message, 2)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.Consume(ctx, queue, func(ctx context.Context, msg *Message) error {
+			got <- msg
+			if msg.Headers[HeaderRetryCount] == "" {
+				return errors.New("retry")
+			}
+			return nil
+		}, WithRetry(1, 100*time.Millisecond))
+	}()
+	time.Sleep(200 * time.Millisecond)
+	if err := p.Send("x"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-got:
+			if msg.Topic != "topology" {
+				t.Fatalf("第%d次投递的 Topic 为 %q", i+1, msg.Topic)
+			}
+		case err := <-errc:
+			t.Fatalf("消费 Topology 中的队列失败: %v", err)
+		case <-ctx.Done():
+			t.Fatal("未收到重试的消息")
+		}
+	}
+}
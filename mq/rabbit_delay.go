@@ -0,0 +1,117 @@
+package mq
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// HeaderDelay is 延迟消息插件使用的延迟毫秒数消息头.
+const HeaderDelay = "x-delay"
+
+// MAX_DELAY_TTL is 使用 TTL 队列时支持的最大延迟, 受队列 x-expires 参数(int32 毫秒)限制.
+const MAX_DELAY_TTL = (math.MaxInt32 - 60000) / 2 * time.Millisecond
+
+// MAX_DELAY_PLUGIN is 使用延迟消息插件时支持的最大延迟.
+const MAX_DELAY_PLUGIN = math.MaxUint32 * time.Millisecond
+
+// delayQueue 返回 TTL 延迟队列名, 延迟按秒向上取整, 避免产生过多队列.
+func (p *RabbitMQ) delayQueue(delay time.Duration) (string, time.Duration) {
+	delay = (delay + time.Second - 1) / time.Second * time.Second
+	return fmt.Sprintf("%s.delay.%d", p.Exchange, delay/time.Second), delay
+}
+
+// delayQueueArgs 返回 TTL 延迟队列的参数.
+// 到期的消息经 Exchange 按原 routing key 投递; 队列空闲超过两倍延迟后自动删除.
+func (p *RabbitMQ) delayQueueArgs(delay time.Duration) amqp.Table {
+	ms := delay.Milliseconds()
+	return amqp.Table{
+		"x-message-ttl":          int32(ms),
+		"x-dead-letter-exchange": p.Exchange,
+		"x-expires":              int32(2*ms + time.Minute.Milliseconds()),
+	}
+}
+
+// checkDelay 检查延迟是否超过上限, TTL 队列的延迟按秒向上取整后检查.
+func (p *RabbitMQ) checkDelay(delay time.Duration) error {
+	max := MAX_DELAY_PLUGIN
+	if !p.DelayedExchange {
+		max = MAX_DELAY_TTL
+		_, delay = p.delayQueue(delay)
+	}
+	if delay > max {
+		return fmt.Errorf("延迟时间%v超过上限%v", delay, max)
+	}
+	return nil
+}
+
+// mandatory returns true if 发送时需要设置 mandatory.
+// 延迟 exchange 在消息到期前不路由消息, 设置 mandatory 会使所有消息都被退回.
+func (p *RabbitMQ) mandatory(delay time.Duration) bool {
+	return p.Mandatory && (delay <= 0 || !p.DelayedExchange)
+}
+
+// delayRoute 在 ch 上声明延迟所需的拓扑, 返回延迟消息应发送到的 exchange.
+// 使用插件时发送到 x-delayed-message 类型的 <Exchange>.delayed, 由插件到期后转发到 Exchange;
+// 否则发送到同名 fanout exchange 绑定的 TTL 队列 <Exchange>.delay.<秒数>.
+func (p *RabbitMQ) delayRoute(ch *amqp.Channel, msg *amqp.Publishing, delay time.Duration) (string, error) {
+	// 每个队列只有一个 TTL, 单条消息的有效期会使其提前到期, 因此清除
+	msg.Expiration = ""
+	if p.DelayedExchange {
+		name := p.Exchange + ".delayed"
+		err := ch.ExchangeDeclare(name, "x-delayed-message", true, false, false, false, amqp.Table{
+			"x-delayed-type": amqp.ExchangeFanout,
+		})
+		if err != nil {
+			return "", fmt.Errorf("RabbitMQ的延迟exchange定义失败,请确认已安装延迟消息插件.%v", err)
+		}
+		if err := ch.ExchangeBind(p.Exchange, "", name, false, nil); err != nil {
+			return "", fmt.Errorf("RabbitMQ的延迟exchange绑定失败.%v", err)
+		}
+		headers := amqp.Table{}
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		headers[HeaderDelay] = delay.Milliseconds()
+		msg.Headers = headers
+		return name, nil
+	}
+
+	name, delay := p.delayQueue(delay)
+	if err := ch.ExchangeDeclare(name, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return "", fmt.Errorf("RabbitMQ的延迟exchange定义失败.%v", err)
+	}
+	if _, err := ch.QueueDeclare(name, true, false, false, false, p.delayQueueArgs(delay)); err != nil {
+		return "", fmt.Errorf("RabbitMQ的延迟队列定义失败.%v", err)
+	}
+	if err := ch.QueueBind(name, "", name, false, nil); err != nil {
+		return "", fmt.Errorf("RabbitMQ的延迟队列绑定失败.%v", err)
+	}
+	return name, nil
+}
+
+// SendDelayed is 向 key 发送延迟 delay 后投递的消息, delay 不大于 0 时立即发送.
+// 未开启 DelayedExchange 时使用 TTL 队列实现, 延迟按秒向上取整;
+// 延迟相同的消息按发送顺序投递, 延迟不同的消息按到期时间投递, 到期时间相同时不保证顺序.
+// 延迟消息忽略 WithExpiry、WithExchange, 延迟超过 MAX_DELAY_TTL 或 MAX_DELAY_PLUGIN 时返回错误.
+// 延迟消息到期后才路由, 发送时 Mandatory 不生效, 届时无法路由的消息被丢弃.
+func (p *RabbitMQ) SendDelayed(key string, payload []byte, delay time.Duration, opts ...PublishOption) error {
+	return p.publishDelayed("", key, p.publishing(payload, newPublishOptions(opts)), delay)
+}
+
+// SendAt is 向 key 发送在 at 时刻投递的消息, at 已过时立即发送.
+func (p *RabbitMQ) SendAt(key string, payload []byte, at time.Time, opts ...PublishOption) error {
+	return p.SendDelayed(key, payload, time.Until(at), opts...)
+}
+
+// SendDelayed is 同 RabbitMQ.SendDelayed.
+func (p *RabbitPublisher) SendDelayed(key string, payload []byte, delay time.Duration, opts ...PublishOption) error {
+	return p.publishDelayed("", key, p.cfg.publishing(payload, newPublishOptions(opts)), delay)
+}
+
+// SendAt is 同 RabbitMQ.SendAt.
+func (p *RabbitPublisher) SendAt(key string, payload []byte, at time.Time, opts ...PublishOption) error {
+	return p.SendDelayed(key, payload, time.Until(at), opts...)
+}
@@ -0,0 +1,141 @@
+package mq
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRabbitMQ_delayQueue(t *testing.T) {
+	p := &RabbitMQ{Exchange: "cmd"}
+	cases := []struct {
+		delay time.Duration
+		name  string
+		ttl   int32
+	}{
+		{time.Second, "cmd.delay.1", 1000},
+		{1500 * time.Millisecond, "cmd.delay.2", 2000},
+		{time.Hour, "cmd.delay.3600", 3600000},
+	}
+	for _, c := range cases {
+		name, delay := p.delayQueue(c.delay)
+		if name != c.name {
+			t.Fatalf("delayQueue(%v) = %s, 期望 %s", c.delay, name, c.name)
+		}
+		args := p.delayQueueArgs(delay)
+		if args["x-message-ttl"] != c.ttl || args["x-dead-letter-exchange"] != "cmd" {
+			t.Fatalf("delayQueueArgs(%v) = %v", delay, args)
+		}
+		if expires := args["x-expires"].(int32); expires <= c.ttl {
+			t.Fatalf("延迟队列在消息到期前被删除: x-expires=%d, ttl=%d", expires, c.ttl)
+		}
+	}
+}
+
+func TestRabbitMQ_checkDelay(t *testing.T) {
+	p := &RabbitMQ{Exchange: "cmd", Mandatory: true}
+	if err := p.checkDelay(MAX_DELAY_TTL / time.Second * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if args := p.delayQueueArgs(MAX_DELAY_TTL / time.Second * time.Second); args["x-expires"].(int32) <= 0 {
+		t.Fatalf("x-expires 溢出: %v", args)
+	}
+	if err := p.checkDelay(MAX_DELAY_TTL + time.Millisecond); err == nil {
+		t.Fatal("延迟超过 MAX_DELAY_TTL 时应返回错误")
+	}
+	if !p.mandatory(time.Second) {
+		t.Fatal("TTL 队列的延迟消息应保留 mandatory")
+	}
+
+	p.DelayedExchange = true
+	if err := p.checkDelay(30 * 24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.checkDelay(MAX_DELAY_PLUGIN + time.Millisecond); err == nil {
+		t.Fatal("延迟超过 MAX_DELAY_PLUGIN 时应返回错误")
+	}
+	if p.mandatory(time.Second) || !p.mandatory(0) {
+		t.Fatal("只有经延迟 exchange 发送的消息不设置 mandatory")
+	}
+}
+
+// rabbitFromEnv 返回 RABBITMQ_HOST 指定的测试 broker, 未设置时跳过测试.
+func rabbitFromEnv(t *testing.T) *RabbitMQ {
+	host := os.Getenv("RABBITMQ_HOST")
+	if host == "" {
+		t.Skip("未设置 RABBITMQ_HOST, 跳过需要 RabbitMQ 的测试")
+	}
+	port, _ := strconv.Atoi(os.Getenv("RABBITMQ_PORT"))
+	if port == 0 {
+		port = 5672
+	}
+	return &RabbitMQ{
+		Host:       host,
+		Port:       port,
+		Username:   "guest",
+		Password:   "guest",
+		Kind:       "direct",
+		Exchange:   "commongo.test",
+		RoutingKey: "delay",
+	}
+}
+
+// TestRabbitMQ_SendDelayed 说明 TTL 队列实现的顺序保证:
+// 延迟相同的消息按发送顺序投递, 延迟不同的消息按到期时间投递.
+func TestRabbitMQ_SendDelayed(t *testing.T) {
+	p := rabbitFromEnv(t)
+	queue := "commongo.test.delay"
+
+	var mu sync.Mutex
+	var got []string
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ready := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		close(ready)
+		errc <- p.Consume(ctx, queue, func(ctx context.Context, msg *Message) error {
+			mu.Lock()
+			got = append(got, string(msg.Payload))
+			if len(got) == 5 {
+				cancel()
+			}
+			mu.Unlock()
+			return nil
+		})
+	}()
+	<-ready
+	time.Sleep(500 * time.Millisecond)
+
+	sends := []struct {
+		payload string
+		delay   time.Duration
+	}{
+		{"late", 3 * time.Second},
+		{"a1", time.Second},
+		{"mid", 2 * time.Second},
+		{"a2", time.Second},
+		{"a3", time.Second},
+	}
+	for _, s := range sends {
+		if err := p.SendDelayed(p.RoutingKey, []byte(s.payload), s.delay); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a1", "a2", "a3", "mid", "late"}
+	if len(got) != len(want) {
+		t.Fatalf("收到 %v, 期望 %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("收到 %v, 期望 %v", got, want)
+		}
+	}
+}
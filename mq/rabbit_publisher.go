@@ -0,0 +1,319 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	defaultRabbitPoolSize = 8
+	defaultRabbitTimeout  = 10
+	maxRabbitBackoff      = 30 * time.Second
+)
+
+var errPublisherClosed = errors.New("RabbitMQ发送者已关闭")
+
+// RabbitPublisher is RabbitMQ 长连接发送者.
+// 连接在多次发送间复用, 管道池支持多个协程并发发送, exchange 在每次建立连接时声明一次.
+// broker 重启等原因导致连接断开后, 后台按退避间隔自动重连并重建管道.
+type RabbitPublisher struct {
+	cfg     *RabbitMQ
+	size    int
+	timeout time.Duration
+
+	mu     sync.Mutex
+	conn   *amqp.Connection
+	gen    int // 每次重连加一, 用于丢弃旧连接上的管道
+	open   int // 当前连接上已打开的管道数
+	closed bool
+
+	pool chan *pooledChannel
+	done chan struct{}
+}
+
+// pooledChannel 为管道池中的一个管道.
+type pooledChannel struct {
+	*amqpChannel
+	gen    int
+	closed chan *amqp.Error
+}
+
+// alive returns true if 管道未关闭.
+func (pc *pooledChannel) alive() bool {
+	select {
+	case <-pc.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// NewPublisher is 创建长连接发送者并立即建立连接.
+func (p *RabbitMQ) NewPublisher() (*RabbitPublisher, error) {
+	size := p.ChannelPoolSize
+	if size <= 0 {
+		size = defaultRabbitPoolSize
+	}
+	pub := &RabbitPublisher{
+		cfg:     p,
+		size:    size,
+		timeout: p.timeout(),
+		pool:    make(chan *pooledChannel, size),
+		done:    make(chan struct{}),
+	}
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	if _, err := pub.connect(); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// connect 返回可用的连接, 连接断开时重新建立, 调用方需持有 mu.
+func (p *RabbitPublisher) connect() (*amqp.Connection, error) {
+	if p.closed {
+		return nil, errPublisherClosed
+	}
+	if p.conn != nil && !p.conn.IsClosed() {
+		return p.conn, nil
+	}
+	conn, err := p.cfg.dial()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("RabbitMQ管道打开失败.%v", err)
+	}
+	err = p.cfg.declare(ch)
+	ch.Close()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p.conn = conn
+	p.gen++
+	p.open = 0
+	go p.watch(conn.NotifyClose(make(chan *amqp.Error, 1)))
+	return conn, nil
+}
+
+// watch 在连接异常断开后自动重连.
+func (p *RabbitPublisher) watch(notify chan *amqp.Error) {
+	if err := <-notify; err == nil {
+		// 主动关闭
+		return
+	}
+	backoff := time.Second
+	for {
+		p.mu.Lock()
+		_, err := p.connect()
+		p.mu.Unlock()
+		if err == nil || err == errPublisherClosed {
+			return
+		}
+		select {
+		case <-p.done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRabbitBackoff {
+			backoff = maxRabbitBackoff
+		}
+	}
+}
+
+// acquire 从管道池取出一个管道, 池中没有空闲管道且未达到上限时新建管道,
+// 否则等待其他协程释放, 超时返回错误.
+func (p *RabbitPublisher) acquire() (*pooledChannel, error) {
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case pc := <-p.pool:
+			if pc, ok := p.check(pc); ok {
+				return pc, nil
+			}
+			continue
+		default:
+		}
+
+		p.mu.Lock()
+		conn, err := p.connect()
+		if err != nil {
+			p.mu.Unlock()
+			return nil, err
+		}
+		if p.open < p.size {
+			p.open++
+			gen := p.gen
+			p.mu.Unlock()
+			c, err := openChannel(conn, p.cfg.confirm())
+			if err != nil {
+				p.discard(&pooledChannel{gen: gen})
+				return nil, err
+			}
+			return &pooledChannel{amqpChannel: c, gen: gen, closed: c.ch.NotifyClose(make(chan *amqp.Error, 1))}, nil
+		}
+		p.mu.Unlock()
+
+		select {
+		case pc := <-p.pool:
+			if pc, ok := p.check(pc); ok {
+				return pc, nil
+			}
+		case <-p.done:
+			return nil, errPublisherClosed
+		case <-timer.C:
+			return nil, fmt.Errorf("等待RabbitMQ空闲管道超时")
+		}
+	}
+}
+
+// check 检查管道是否可用, 不可用的管道被关闭.
+func (p *RabbitPublisher) check(pc *pooledChannel) (*pooledChannel, bool) {
+	p.mu.Lock()
+	gen := p.gen
+	p.mu.Unlock()
+	if pc.gen == gen && pc.alive() {
+		return pc, true
+	}
+	p.discard(pc)
+	return nil, false
+}
+
+// release 将管道放回池中, err 不为空时管道可能已失效, 直接关闭.
+func (p *RabbitPublisher) release(pc *pooledChannel, err error) {
+	if err != nil {
+		p.discard(pc)
+		return
+	}
+	select {
+	case p.pool <- pc:
+	default:
+		p.discard(pc)
+	}
+}
+
+// discard 关闭管道并释放其占用的名额.
+func (p *RabbitPublisher) discard(pc *pooledChannel) {
+	if pc.amqpChannel != nil {
+		pc.ch.Close()
+	}
+	p.mu.Lock()
+	if pc.gen == p.gen && p.open > 0 {
+		p.open--
+	}
+	p.mu.Unlock()
+}
+
+// Publish is 向配置的 Exchange 或 WithExchange 指定的 exchange 发送原始数据, topic 与 routing key 的转换及消息属性同 RabbitMQ.Publish.
+// 开启 Confirm 时等待 broker 确认, 消息被退回时返回 *ReturnedError, 被拒绝时返回 ErrNacked.
+func (p *RabbitPublisher) Publish(topic string, payload []byte, opts ...PublishOption) error {
+	key, err := topicKey(topic)
+	if err != nil {
+		return err
+	}
+	return p.PublishKey(key, payload, opts...)
+}
+
+// Subscribe is 同 RabbitMQ.Subscribe, 使用独立的连接.
+func (p *RabbitPublisher) Subscribe(ctx context.Context, topic string, h Handler, opts ...SubscribeOption) error {
+	return p.cfg.Subscribe(ctx, topic, h, opts...)
+}
+
+// PublishKey is 使用 key 作为 routing key 发送原始数据, 同 RabbitMQ.PublishKey.
+func (p *RabbitPublisher) PublishKey(key string, payload []byte, opts ...PublishOption) error {
+	o := newPublishOptions(opts)
+	return p.publishDelayed(o.exchange, key, p.cfg.publishing(payload, o), 0)
+}
+
+func (p *RabbitPublisher) publish(key string, msg amqp.Publishing) error {
+	return p.publishDelayed("", key, msg, 0)
+}
+
+// publishDelayed 同 RabbitMQ.publishDelayed.
+func (p *RabbitPublisher) publishDelayed(exchange, key string, msg amqp.Publishing, delay time.Duration) error {
+	if err := p.cfg.checkDelay(delay); err != nil {
+		return err
+	}
+	pc, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	if exchange == "" {
+		exchange = p.cfg.Exchange
+	}
+	if delay > 0 {
+		if exchange, err = p.cfg.delayRoute(pc.ch, &msg, delay); err != nil {
+			p.release(pc, err)
+			return err
+		}
+	}
+	err = pc.publish(exchange, key, p.cfg.mandatory(delay), msg, p.timeout)
+	// 退回和拒绝不影响管道继续使用, 其他错误如确认超时后管道状态未知, 不再复用
+	var returned *ReturnedError
+	if errors.As(err, &returned) || err == ErrNacked {
+		p.release(pc, nil)
+	} else {
+		p.release(pc, err)
+	}
+	if err != nil {
+		return err
+	}
+	p.cfg.record(key, msg)
+	return nil
+}
+
+// Send is 向 RoutingKey 发送文本消息.
+func (p *RabbitPublisher) Send(msg string, opts ...PublishOption) error {
+	return p.publish(p.cfg.RoutingKey, p.cfg.publishing([]byte(msg), newPublishOptions(textOptions(opts))))
+}
+
+// SendValue is 按 Codec 编码、Compression 压缩后向 RoutingKey 发送 val.
+func (p *RabbitPublisher) SendValue(val interface{}, opts ...PublishOption) error {
+	o := newPublishOptions(opts)
+	body, err := p.cfg.encode(val, o)
+	if err != nil {
+		return err
+	}
+	return p.publish(p.cfg.RoutingKey, p.cfg.publishing(body, o))
+}
+
+// Close is 关闭所有管道和连接.
+func (p *RabbitPublisher) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	conn := p.conn
+	p.conn = nil
+	p.mu.Unlock()
+
+	p.drain()
+	if conn == nil || conn.IsClosed() {
+		return nil
+	}
+	return conn.Close()
+}
+
+// drain 关闭池中所有空闲管道.
+func (p *RabbitPublisher) drain() {
+	for {
+		select {
+		case pc := <-p.pool:
+			pc.ch.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package mq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// rabbitQueue 在测试 broker 上声明绑定到 p.Exchange 的临时队列, 测试结束时删除.
+func rabbitQueue(t *testing.T, p *RabbitMQ, name, key string, args amqp.Table) *amqp.Channel {
+	conn, err := p.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.declare(ch); err != nil {
+		t.Fatal(err)
+	}
+	ch.QueueDelete(name, false, false, false)
+	if _, err := ch.QueueDeclare(name, false, false, false, false, args); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.QueueBind(name, key, p.Exchange, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ch.QueueDelete(name, false, false, false) })
+	return ch
+}
+
+// waitQueue 等待队列中的消息数达到 n.
+func waitQueue(t *testing.T, ch *amqp.Channel, name string, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		q, err := ch.QueueInspect(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Messages == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("队列%s中有%d条消息, 期望%d条", name, q.Messages, n)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRabbitPublisher_Pool(t *testing.T) {
+	const n = 50
+	p := rabbitFromEnv(t)
+	p.RoutingKey = "pool"
+	p.ChannelPoolSize = 2
+	p.Confirm = true
+	ch := rabbitQueue(t, p, "commongo.test.pool", "pool", nil)
+
+	pub, err := p.NewPublisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pub.Send("x"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	waitQueue(t, ch, "commongo.test.pool", n)
+	pub.mu.Lock()
+	open := pub.open
+	pub.mu.Unlock()
+	if open > p.ChannelPoolSize {
+		t.Fatalf("打开了%d个管道, 超过管道池大小%d", open, p.ChannelPoolSize)
+	}
+}
+
+func TestRabbitPublisher_Reconnect(t *testing.T) {
+	p := rabbitFromEnv(t)
+	p.RoutingKey = "reconnect"
+	p.Confirm = true
+	ch := rabbitQueue(t, p, "commongo.test.reconnect", "reconnect", nil)
+
+	pub, err := p.NewPublisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	if err := pub.Send("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 连接断开后池中的管道失效, 下一次发送重新建立连接
+	pub.mu.Lock()
+	old, gen := pub.conn, pub.gen
+	pub.mu.Unlock()
+	old.Close()
+	if err := pub.Send("2"); err != nil {
+		t.Fatal(err)
+	}
+	pub.mu.Lock()
+	if pub.gen != gen+1 || pub.conn == old {
+		t.Fatalf("发送时未重建连接, gen=%d", pub.gen)
+	}
+	old, gen = pub.conn, pub.gen
+	pub.mu.Unlock()
+
+	// 连接异常断开时 watch 在后台重连
+	notify := make(chan *amqp.Error, 1)
+	notify <- &amqp.Error{Code: amqp.ConnectionForced, Reason: "test"}
+	old.Close()
+	go pub.watch(notify)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pub.mu.Lock()
+		reconnected := pub.gen > gen && pub.conn != nil && !pub.conn.IsClosed()
+		pub.mu.Unlock()
+		if reconnected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("连接断开后未自动重连")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := pub.Send("3"); err != nil {
+		t.Fatal(err)
+	}
+	waitQueue(t, ch, "commongo.test.reconnect", 3)
+
+	pub.Close()
+	if err := pub.Send("4"); err != errPublisherClosed {
+		t.Fatalf("关闭后发送应返回 errPublisherClosed, 实际 %v", err)
+	}
+}
+
+func TestRabbitMQ_WithExchange(t *testing.T) {
+	p := rabbitFromEnv(t)
+	other := rabbitFromEnv(t)
+	other.Exchange = "commongo.test.other"
+	other.Kind = "topic"
+	ch := rabbitQueue(t, other, "commongo.test.other", "orders.created", nil)
+
+	// 发送到 WithExchange 指定的 exchange, 而不是配置的 Exchange
+	if err := p.Publish("orders/created", []byte("1"), WithExchange(other.Exchange)); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := p.NewPublisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	if err := pub.PublishKey("orders.created", []byte("2"), WithExchange(other.Exchange)); err != nil {
+		t.Fatal(err)
+	}
+	waitQueue(t, ch, "commongo.test.other", 2)
+
+	// 订阅 WithSubscribeExchange 指定的 exchange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan *Message, 1)
+	go p.Subscribe(ctx, "orders/+", func(ctx context.Context, msg *Message) error {
+		got <- msg
+		return nil
+	}, WithSubscribeExchange(other.Exchange))
+	time.Sleep(200 * time.Millisecond)
+	if err := other.Publish("orders/paid", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-got:
+		if msg.Topic != "orders/paid" || string(msg.Payload) != "3" {
+			t.Fatalf("收到的消息错误: %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("未收到订阅的 exchange 中的消息")
+	}
+}
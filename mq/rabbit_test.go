@@ -0,0 +1,65 @@
+package mq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRabbitMQ_publishing(t *testing.T) {
+	p := &RabbitMQ{}
+	msg := p.publishing([]byte("x"), newPublishOptions(nil))
+	if msg.Expiration != defaultRabbitExpiration || msg.DeliveryMode != 0 {
+		t.Fatalf("默认消息属性错误: %+v", msg)
+	}
+
+	ts := time.Unix(1700000000, 0)
+	msg = p.publishing([]byte("x"), newPublishOptions([]PublishOption{
+		WithPersistent(true),
+		WithPriority(5),
+		WithHeader("tenant", "t1"),
+		WithExpiry(1500 * time.Millisecond),
+		WithCorrelationID("c1"),
+		WithReplyTo("replies"),
+		WithMessageID("m1"),
+		WithTimestamp(ts),
+		WithContentType("application/octet-stream"),
+	}))
+	want := amqp.Publishing{
+		Headers:       amqp.Table{"tenant": "t1"},
+		ContentType:   "application/octet-stream",
+		DeliveryMode:  amqp.Persistent,
+		Priority:      5,
+		CorrelationId: "c1",
+		ReplyTo:       "replies",
+		Expiration:    "1500",
+		MessageId:     "m1",
+		Timestamp:     ts,
+	}
+	if msg.Headers["tenant"] != "t1" || msg.ContentType != want.ContentType || msg.DeliveryMode != want.DeliveryMode ||
+		msg.Priority != want.Priority || msg.CorrelationId != want.CorrelationId || msg.ReplyTo != want.ReplyTo ||
+		msg.Expiration != want.Expiration || msg.MessageId != want.MessageId || !msg.Timestamp.Equal(ts) {
+		t.Fatalf("消息属性为 %+v, 期望 %+v", msg, want)
+	}
+
+	msg = p.publishing(nil, newPublishOptions([]PublishOption{WithExpiry(0)}))
+	if msg.Expiration != "" {
+		t.Fatalf("WithExpiry(0) 应取消有效期, 实际为 %q", msg.Expiration)
+	}
+}
+
+func TestRabbitMQ_topicKey(t *testing.T) {
+	if key, err := topicKey("orders/created"); err != nil || key != "orders.created" {
+		t.Fatalf("topicKey = %q, %v", key, err)
+	}
+	// 含 . 的 topic 在其他 broker 中是一级, RabbitMQ 订阅者会收到 orders/created, 因此拒绝
+	p := &RabbitMQ{}
+	if err := p.Publish("orders.created", []byte("x")); err == nil {
+		t.Fatal("topic 包含 . 时 Publish 应返回错误")
+	}
+	if err := p.Subscribe(context.Background(), "orders.created", nil); err == nil {
+		t.Fatal("topic 包含 . 时 Subscribe 应返回错误")
+	}
+}
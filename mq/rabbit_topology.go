@@ -0,0 +1,288 @@
+package mq
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/streadway/amqp"
+)
+
+// Topology is RabbitMQ 拓扑配置, 启动时按顺序声明 exchange、队列和绑定.
+type Topology struct {
+	Exchanges []ExchangeConfig `json:"exchanges" toml:"exchanges" description:"exchange列表"`
+	Queues    []QueueConfig    `json:"queues" toml:"queues" description:"队列列表"`
+	Bindings  []BindingConfig  `json:"bindings" toml:"bindings" description:"绑定列表"`
+}
+
+// ExchangeConfig is exchange 配置.
+type ExchangeConfig struct {
+	Name       string                 `json:"name" toml:"name" description:"exchange名"`
+	Kind       string                 `json:"kind" toml:"kind" description:"类型:direct、fanout、topic、headers"`
+	Durable    bool                   `json:"durable" toml:"durable" description:"是否持久化"`
+	AutoDelete bool                   `json:"autoDelete" toml:"autoDelete" description:"没有绑定时是否自动删除"`
+	Internal   bool                   `json:"internal" toml:"internal" description:"是否只允许exchange间转发"`
+	Arguments  map[string]interface{} `json:"arguments" toml:"arguments" description:"其他参数"`
+}
+
+// QueueConfig is 队列配置.
+type QueueConfig struct {
+	Name                 string                 `json:"name" toml:"name" description:"队列名"`
+	Durable              bool                   `json:"durable" toml:"durable" description:"是否持久化"`
+	AutoDelete           bool                   `json:"autoDelete" toml:"autoDelete" description:"没有消费者时是否自动删除"`
+	Exclusive            bool                   `json:"exclusive" toml:"exclusive" description:"是否为连接独占"`
+	MessageTTL           int                    `json:"messageTtl" toml:"messageTtl" description:"消息有效期,单位毫秒,0为不限制"`
+	MaxLength            int                    `json:"maxLength" toml:"maxLength" description:"队列最大消息数,0为不限制"`
+	DeadLetterExchange   string                 `json:"deadLetterExchange" toml:"deadLetterExchange" description:"死信exchange"`
+	DeadLetterRoutingKey string                 `json:"deadLetterRoutingKey" toml:"deadLetterRoutingKey" description:"死信routing key,为空时使用原routing key"`
+	Arguments            map[string]interface{} `json:"arguments" toml:"arguments" description:"其他参数"`
+}
+
+// BindingConfig is 队列与 exchange 的绑定配置.
+type BindingConfig struct {
+	Queue      string                 `json:"queue" toml:"queue" description:"队列名"`
+	Exchange   string                 `json:"exchange" toml:"exchange" description:"exchange名"`
+	RoutingKey string                 `json:"routingKey" toml:"routingKey" description:"routing key"`
+	Arguments  map[string]interface{} `json:"arguments" toml:"arguments" description:"其他参数"`
+}
+
+// TopologyDiff is 配置与 broker 上实际拓扑的一处差异.
+type TopologyDiff struct {
+	Kind    string // exchange、queue、binding
+	Name    string
+	Problem string
+}
+
+func (d TopologyDiff) String() string {
+	return fmt.Sprintf("%s %s: %s", d.Kind, d.Name, d.Problem)
+}
+
+// args 返回队列参数.
+func (q *QueueConfig) args() amqp.Table {
+	t := table(q.Arguments)
+	if q.MessageTTL > 0 {
+		t["x-message-ttl"] = int32(q.MessageTTL)
+	}
+	if q.MaxLength > 0 {
+		t["x-max-length"] = int32(q.MaxLength)
+	}
+	if q.DeadLetterExchange != "" {
+		t["x-dead-letter-exchange"] = q.DeadLetterExchange
+	}
+	if q.DeadLetterRoutingKey != "" {
+		t["x-dead-letter-routing-key"] = q.DeadLetterRoutingKey
+	}
+	return t
+}
+
+// table 将配置中的参数转换为 amqp.Table.
+// 配置解析得到的整数统一转换为 int32, 与 broker 比较参数时类型一致.
+func table(m map[string]interface{}) amqp.Table {
+	t := amqp.Table{}
+	for k, v := range m {
+		switch n := v.(type) {
+		case float64:
+			if n == math.Trunc(n) && math.Abs(n) <= math.MaxInt32 {
+				v = int32(n)
+			}
+		case int64:
+			if n >= math.MinInt32 && n <= math.MaxInt32 {
+				v = int32(n)
+			}
+		case int:
+			if n >= math.MinInt32 && n <= math.MaxInt32 {
+				v = int32(n)
+			}
+		}
+		t[k] = v
+	}
+	return t
+}
+
+// hasExchange returns true if 拓扑中声明了 name.
+func (t *Topology) hasExchange(name string) bool {
+	if t == nil {
+		return false
+	}
+	for _, e := range t.Exchanges {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasQueue returns true if 拓扑中声明了 name.
+func (t *Topology) hasQueue(name string) bool {
+	if t == nil {
+		return false
+	}
+	for _, q := range t.Queues {
+		if q.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// apply 在 ch 上声明拓扑, 已存在且参数一致时不做修改.
+func (t *Topology) apply(ch *amqp.Channel) error {
+	for _, e := range t.Exchanges {
+		if err := declareExchange(ch, e); err != nil {
+			return fmt.Errorf("RabbitMQ的exchange %s定义失败.%v", e.Name, err)
+		}
+	}
+	for _, q := range t.Queues {
+		if err := declareQueue(ch, q); err != nil {
+			return fmt.Errorf("RabbitMQ的队列%s定义失败.%v", q.Name, err)
+		}
+	}
+	for _, b := range t.Bindings {
+		if err := ch.QueueBind(b.Queue, b.RoutingKey, b.Exchange, false, table(b.Arguments)); err != nil {
+			return fmt.Errorf("RabbitMQ的队列%s绑定%s失败.%v", b.Queue, b.Exchange, err)
+		}
+	}
+	return nil
+}
+
+func declareExchange(ch *amqp.Channel, e ExchangeConfig) error {
+	return ch.ExchangeDeclare(e.Name, e.Kind, e.Durable, e.AutoDelete, e.Internal, false, table(e.Arguments))
+}
+
+func declareQueue(ch *amqp.Channel, q QueueConfig) error {
+	_, err := ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.args())
+	return err
+}
+
+// DeclareTopology is 连接 broker 并声明 Topology 配置的拓扑, 可重复调用.
+// RabbitPublisher 建立连接时同样会声明拓扑.
+func (p *RabbitMQ) DeclareTopology() error {
+	if p.Topology == nil {
+		return nil
+	}
+	conn, err := p.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("RabbitMQ管道打开失败.%v", err)
+	}
+	defer ch.Close()
+	return p.Topology.apply(ch)
+}
+
+// DiffTopology is 比较 Topology 配置与 broker 上的实际拓扑, 不做任何修改.
+// 返回不存在、参数不一致以及被其他连接独占的 exchange、队列; AMQP 无法查询绑定,
+// 绑定只检查两端是否存在.
+func (p *RabbitMQ) DiffTopology() ([]TopologyDiff, error) {
+	if p.Topology == nil {
+		return nil, nil
+	}
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var diffs []TopologyDiff
+	exists := make(map[string]bool)
+	// 检查失败会关闭管道, 因此每次检查使用新的管道
+	check := func(kind, name string, passive, declare func(ch *amqp.Channel) error) error {
+		problem, err := probe(conn, passive)
+		if err != nil {
+			return err
+		}
+		if problem == "" {
+			problem, err = probe(conn, declare)
+			if err != nil {
+				return err
+			}
+		}
+		exists[kind+":"+name] = problem != "不存在"
+		if problem != "" {
+			diffs = append(diffs, TopologyDiff{Kind: kind, Name: name, Problem: problem})
+		}
+		return nil
+	}
+
+	for _, e := range p.Topology.Exchanges {
+		e := e
+		err := check("exchange", e.Name, func(ch *amqp.Channel) error {
+			return ch.ExchangeDeclarePassive(e.Name, e.Kind, e.Durable, e.AutoDelete, e.Internal, false, nil)
+		}, func(ch *amqp.Channel) error {
+			return declareExchange(ch, e)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, q := range p.Topology.Queues {
+		q := q
+		err := check("queue", q.Name, func(ch *amqp.Channel) error {
+			_, err := ch.QueueDeclarePassive(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, nil)
+			return err
+		}, func(ch *amqp.Channel) error {
+			return declareQueue(ch, q)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, b := range p.Topology.Bindings {
+		name := b.Queue + "->" + b.Exchange
+		for _, end := range []struct{ kind, name string }{{"queue", b.Queue}, {"exchange", b.Exchange}} {
+			ok, checked := exists[end.kind+":"+end.name]
+			if !checked {
+				var passive func(ch *amqp.Channel) error
+				if end.kind == "queue" {
+					passive = func(ch *amqp.Channel) error {
+						_, err := ch.QueueInspect(end.name)
+						return err
+					}
+				} else {
+					passive = func(ch *amqp.Channel) error {
+						return ch.ExchangeDeclarePassive(end.name, amqp.ExchangeDirect, false, false, false, false, nil)
+					}
+				}
+				problem, err := probe(conn, passive)
+				if err != nil {
+					return nil, err
+				}
+				ok = problem != "不存在"
+			}
+			if !ok {
+				diffs = append(diffs, TopologyDiff{Kind: "binding", Name: name, Problem: end.kind + " " + end.name + "不存在"})
+			}
+		}
+	}
+	return diffs, nil
+}
+
+// probe 在新管道上执行 fn, 将 broker 返回的错误转换为差异描述.
+func probe(conn *amqp.Connection, fn func(ch *amqp.Channel) error) (string, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return "", fmt.Errorf("RabbitMQ管道打开失败.%v", err)
+	}
+	defer ch.Close()
+	return diffProblem(fn(ch))
+}
+
+// diffProblem 将 broker 返回的 404、405、406 错误转换为差异描述, 其他错误原样返回.
+// 405 表示队列被其他连接独占, 队列存在但无法检查参数.
+func diffProblem(err error) (string, error) {
+	var ae *amqp.Error
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.As(err, &ae) && ae.Code == amqp.NotFound:
+		return "不存在", nil
+	case errors.As(err, &ae) && ae.Code == amqp.ResourceLocked:
+		return "被其他连接独占,无法检查参数." + ae.Reason, nil
+	case errors.As(err, &ae) && ae.Code == amqp.PreconditionFailed:
+		return "参数不一致." + ae.Reason, nil
+	}
+	return "", err
+}
@@ -0,0 +1,63 @@
+package mq
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestTable(t *testing.T) {
+	got := table(map[string]interface{}{
+		"ttl":     float64(60000),
+		"ratio":   0.5,
+		"big":     float64(1 << 40),
+		"i64":     int64(-5),
+		"i64big":  int64(1 << 40),
+		"int":     7,
+		"mode":    "lazy",
+		"enabled": true,
+	})
+	want := amqp.Table{
+		"ttl":     int32(60000),
+		"ratio":   0.5,
+		"big":     float64(1 << 40),
+		"i64":     int32(-5),
+		"i64big":  int64(1 << 40),
+		"int":     int32(7),
+		"mode":    "lazy",
+		"enabled": true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("table = %v, 期望 %v", got, want)
+	}
+
+	q := QueueConfig{MessageTTL: 1000, DeadLetterExchange: "dlx", Arguments: map[string]interface{}{"x-max-priority": float64(10)}}
+	want = amqp.Table{"x-message-ttl": int32(1000), "x-dead-letter-exchange": "dlx", "x-max-priority": int32(10)}
+	if got := q.args(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("args = %v, 期望 %v", got, want)
+	}
+}
+
+func TestDiffProblem(t *testing.T) {
+	cases := []struct {
+		err     error
+		problem string
+	}{
+		{nil, ""},
+		{&amqp.Error{Code: amqp.NotFound}, "不存在"},
+		{&amqp.Error{Code: amqp.ResourceLocked, Reason: "locked"}, "被其他连接独占,无法检查参数.locked"},
+		{&amqp.Error{Code: amqp.PreconditionFailed, Reason: "inequivalent arg"}, "参数不一致.inequivalent arg"},
+	}
+	for _, c := range cases {
+		problem, err := diffProblem(c.err)
+		if err != nil || problem != c.problem {
+			t.Fatalf("diffProblem(%v) = %q, %v, 期望 %q", c.err, problem, err, c.problem)
+		}
+	}
+	fail := &amqp.Error{Code: amqp.AccessRefused}
+	if _, err := diffProblem(fail); !errors.Is(err, fail) {
+		t.Fatalf("其他错误应原样返回, 实际 %v", err)
+	}
+}
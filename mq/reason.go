@@ -0,0 +1,89 @@
+package mq
+
+import "fmt"
+
+// ReasonCode is MQTT 5 原因码, 大于等于 0x80 表示失败.
+type ReasonCode byte
+
+// MQTT 5 常用原因码.
+const (
+	REASON_SUCCESS                              ReasonCode = 0x00
+	REASON_NO_MATCHING_SUBSCRIBERS              ReasonCode = 0x10
+	REASON_UNSPECIFIED_ERROR                    ReasonCode = 0x80
+	REASON_MALFORMED_PACKET                     ReasonCode = 0x81
+	REASON_PROTOCOL_ERROR                       ReasonCode = 0x82
+	REASON_IMPLEMENTATION_SPECIFIC_ERROR        ReasonCode = 0x83
+	REASON_UNSUPPORTED_PROTOCOL_VERSION         ReasonCode = 0x84
+	REASON_CLIENT_IDENTIFIER_NOT_VALID          ReasonCode = 0x85
+	REASON_BAD_USERNAME_OR_PASSWORD             ReasonCode = 0x86
+	REASON_NOT_AUTHORIZED                       ReasonCode = 0x87
+	REASON_SERVER_UNAVAILABLE                   ReasonCode = 0x88
+	REASON_SERVER_BUSY                          ReasonCode = 0x89
+	REASON_BANNED                               ReasonCode = 0x8A
+	REASON_SESSION_TAKEN_OVER                   ReasonCode = 0x8E
+	REASON_TOPIC_FILTER_INVALID                 ReasonCode = 0x8F
+	REASON_TOPIC_NAME_INVALID                   ReasonCode = 0x90
+	REASON_PACKET_IDENTIFIER_IN_USE             ReasonCode = 0x91
+	REASON_PACKET_TOO_LARGE                     ReasonCode = 0x95
+	REASON_QUOTA_EXCEEDED                       ReasonCode = 0x97
+	REASON_PAYLOAD_FORMAT_INVALID               ReasonCode = 0x99
+	REASON_RETAIN_NOT_SUPPORTED                 ReasonCode = 0x9A
+	REASON_QOS_NOT_SUPPORTED                    ReasonCode = 0x9B
+	REASON_SHARED_SUBSCRIPTIONS_NOT_SUPPORTED   ReasonCode = 0x9E
+	REASON_WILDCARD_SUBSCRIPTIONS_NOT_SUPPORTED ReasonCode = 0xA2
+)
+
+var reasonNames = map[ReasonCode]string{
+	REASON_SUCCESS:                              "成功",
+	REASON_NO_MATCHING_SUBSCRIBERS:              "没有匹配的订阅者",
+	REASON_UNSPECIFIED_ERROR:                    "未指明的错误",
+	REASON_MALFORMED_PACKET:                     "无效报文",
+	REASON_PROTOCOL_ERROR:                       "协议错误",
+	REASON_IMPLEMENTATION_SPECIFIC_ERROR:        "实现特定错误",
+	REASON_UNSUPPORTED_PROTOCOL_VERSION:         "协议版本不支持",
+	REASON_CLIENT_IDENTIFIER_NOT_VALID:          "客户端ID无效",
+	REASON_BAD_USERNAME_OR_PASSWORD:             "用户名或密码错误",
+	REASON_NOT_AUTHORIZED:                       "未授权",
+	REASON_SERVER_UNAVAILABLE:                   "服务端不可用",
+	REASON_SERVER_BUSY:                          "服务端正忙",
+	REASON_BANNED:                               "禁止访问",
+	REASON_SESSION_TAKEN_OVER:                   "会话被接管",
+	REASON_TOPIC_FILTER_INVALID:                 "topic过滤器无效",
+	REASON_TOPIC_NAME_INVALID:                   "topic名无效",
+	REASON_PACKET_IDENTIFIER_IN_USE:             "报文标识已被占用",
+	REASON_PACKET_TOO_LARGE:                     "报文过长",
+	REASON_QUOTA_EXCEEDED:                       "超出配额",
+	REASON_PAYLOAD_FORMAT_INVALID:               "载荷格式无效",
+	REASON_RETAIN_NOT_SUPPORTED:                 "不支持保留消息",
+	REASON_QOS_NOT_SUPPORTED:                    "不支持的QoS等级",
+	REASON_SHARED_SUBSCRIPTIONS_NOT_SUPPORTED:   "不支持共享订阅",
+	REASON_WILDCARD_SUBSCRIPTIONS_NOT_SUPPORTED: "不支持通配符订阅",
+}
+
+func (c ReasonCode) String() string {
+	if s, ok := reasonNames[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("0x%02X", byte(c))
+}
+
+// IsError returns true if 原因码表示失败.
+func (c ReasonCode) IsError() bool {
+	return c >= 0x80
+}
+
+// ReasonCodeError is MQTT 5 broker 返回失败原因码时的错误.
+// 可通过 errors.As 取出原因码判断失败原因.
+type ReasonCodeError struct {
+	// Op 为失败的操作, 如 connect、publish、subscribe.
+	Op     string
+	Code   ReasonCode
+	Reason string
+}
+
+func (e *ReasonCodeError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("EMQTT %s失败.%s(%s)", e.Op, e.Code, e.Reason)
+	}
+	return fmt.Sprintf("EMQTT %s失败.%s", e.Op, e.Code)
+}
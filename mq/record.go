@@ -0,0 +1,272 @@
+package mq
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Direction is 消息方向.
+type Direction byte
+
+const (
+	// DIRECTION_PUBLISH 发送的消息.
+	DIRECTION_PUBLISH Direction = 1
+	// DIRECTION_RECEIVE 收到的消息.
+	DIRECTION_RECEIVE Direction = 2
+)
+
+// Record is 录制的一条消息.
+type Record struct {
+	Time      time.Time
+	Broker    string // emqtt、rabbitmq
+	Direction Direction
+	Topic     string
+	Payload   []byte
+	Qos       byte
+	Retain    bool
+}
+
+// recordMagic 为录制文件头, 最后一字节为格式版本.
+var recordMagic = []byte("MQREC\x01")
+
+var errBadRecord = errors.New("录制文件格式错误")
+
+// maxRecordField 为记录中单个字段的最大长度, 与 MQTT 报文的最大长度相同,
+// 防止损坏的文件中的长度导致分配过大的内存.
+const maxRecordField = 256 << 20
+
+// Recorder is 消息录制器, 将消息按顺序写入紧凑的二进制文件.
+// 每条记录为: 距上一条的纳秒数(varint)、标志位(方向、QoS、保留)、
+// broker、topic、payload(均为 uvarint 长度加内容).
+// 设置到 Emqtt、RabbitMQ 的 Recorder 字段后自动录制发送和收到的消息.
+type Recorder struct {
+	mu   sync.Mutex
+	w    *bufio.Writer
+	c    io.Closer
+	last int64
+	err  error
+}
+
+// NewRecorder is 创建写入 w 的录制器, w 实现 io.Closer 时由 Close 关闭.
+func NewRecorder(w io.Writer) *Recorder {
+	r := &Recorder{w: bufio.NewWriter(w)}
+	if c, ok := w.(io.Closer); ok {
+		r.c = c
+	}
+	_, r.err = r.w.Write(recordMagic)
+	return r
+}
+
+// CreateRecorder is 创建录制文件, 文件已存在时覆盖.
+func CreateRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("创建录制文件失败.%v", err)
+	}
+	return NewRecorder(f), nil
+}
+
+// Record is 写入一条记录, Time 为零值时使用当前时间. 每条记录写入后立即刷新,
+// 进程崩溃时已录制的消息不会丢失.
+func (r *Recorder) Record(rec Record) error {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	ts := rec.Time.UnixNano()
+	delta := ts - r.last
+	if r.last == 0 {
+		delta = ts
+	}
+	r.last = ts
+
+	flags := byte(rec.Direction)&0x03 | (rec.Qos&0x03)<<2
+	if rec.Retain {
+		flags |= 0x10
+	}
+	b := binary.AppendVarint(nil, delta)
+	b = append(b, flags)
+	b = appendRecordField(b, []byte(rec.Broker))
+	b = appendRecordField(b, []byte(rec.Topic))
+	b = appendRecordField(b, rec.Payload)
+	if _, err := r.w.Write(b); err != nil {
+		r.err = err
+		return err
+	}
+	r.err = r.w.Flush()
+	return r.err
+}
+
+// Handler is 返回先录制收到的消息再交给 h 处理的 Handler.
+func (r *Recorder) Handler(broker string, h Handler) Handler {
+	return func(ctx context.Context, msg *Message) error {
+		r.Record(Record{
+			Broker:    broker,
+			Direction: DIRECTION_RECEIVE,
+			Topic:     msg.Topic,
+			Payload:   msg.Payload,
+			Qos:       msg.Qos,
+			Retain:    msg.Retain,
+		})
+		return h(ctx, msg)
+	}
+}
+
+// Close is 刷新缓冲并关闭底层文件.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.w.Flush()
+	if r.err == nil {
+		r.err = errors.New("录制器已关闭")
+	}
+	if r.c != nil {
+		if cerr := r.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func appendRecordField(b, field []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(field)))
+	return append(b, field...)
+}
+
+// RecordReader is 按顺序读取录制文件中的记录.
+type RecordReader struct {
+	r    *bufio.Reader
+	last int64
+}
+
+// NewRecordReader is 创建读取 r 的 RecordReader, 并校验文件头.
+func NewRecordReader(r io.Reader) (*RecordReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != string(recordMagic) {
+		return nil, errBadRecord
+	}
+	return &RecordReader{r: br}, nil
+}
+
+// Next is 读取下一条记录, 读完时返回 io.EOF.
+func (rr *RecordReader) Next() (*Record, error) {
+	delta, err := binary.ReadVarint(rr.r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, errBadRecord
+	}
+	flags, err := rr.r.ReadByte()
+	if err != nil {
+		return nil, errBadRecord
+	}
+	var fields [3][]byte
+	for i := range fields {
+		n, err := binary.ReadUvarint(rr.r)
+		if err != nil || n > maxRecordField {
+			return nil, errBadRecord
+		}
+		fields[i] = make([]byte, n)
+		if _, err := io.ReadFull(rr.r, fields[i]); err != nil {
+			return nil, errBadRecord
+		}
+	}
+	rr.last += delta
+	return &Record{
+		Time:      time.Unix(0, rr.last),
+		Broker:    string(fields[0]),
+		Direction: Direction(flags & 0x03),
+		Topic:     string(fields[1]),
+		Payload:   fields[2],
+		Qos:       flags >> 2 & 0x03,
+		Retain:    flags&0x10 != 0,
+	}, nil
+}
+
+// Player is 录制消息回放器.
+type Player struct {
+	// Speed 回放倍速, 0 或 1 为原始速度, 2 为两倍速, 小于 0 时不等待.
+	Speed float64
+	// Direction 只回放该方向的消息, 0 为全部.
+	Direction Direction
+	// Broker 只回放该 broker 的消息, 为空时全部回放.
+	Broker string
+}
+
+// Play is 按录制的时间间隔将消息交给 h, 阻塞直到回放完成或 ctx 结束.
+// h 返回错误时停止回放并返回该错误.
+func (p *Player) Play(ctx context.Context, r io.Reader, h Handler) error {
+	rr, err := NewRecordReader(r)
+	if err != nil {
+		return err
+	}
+	speed := p.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	var prev time.Time
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for {
+		rec, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if (p.Direction != 0 && rec.Direction != p.Direction) || (p.Broker != "" && rec.Broker != p.Broker) {
+			continue
+		}
+		if speed > 0 && !prev.IsZero() {
+			if d := time.Duration(float64(rec.Time.Sub(prev)) / speed); d > 0 {
+				timer.Reset(d)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		prev = rec.Time
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err = h(ctx, &Message{Topic: rec.Topic, Payload: rec.Payload, Qos: rec.Qos, Retain: rec.Retain})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// PlayFile is 回放录制文件.
+func (p *Player) PlayFile(ctx context.Context, path string, h Handler) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("打开录制文件失败.%v", err)
+	}
+	defer f.Close()
+	return p.Play(ctx, f, h)
+}
+
+// PublishHandler is 返回将消息按原 topic、QoS、保留标志发送的 Handler,
+// 用于将录制的消息回放到 broker, 如 PublishHandler(emqtt.Publish).
+func PublishHandler(publish func(topic string, payload []byte, opts ...PublishOption) error) Handler {
+	return func(ctx context.Context, msg *Message) error {
+		return publish(msg.Topic, msg.Payload, WithQos(msg.Qos), WithRetain(msg.Retain))
+	}
+}
@@ -0,0 +1,89 @@
+package mq_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/zhgqiang/commongo/mq"
+)
+
+func TestPlayer_Play(t *testing.T) {
+	var buf bytes.Buffer
+	r := mq.NewRecorder(&buf)
+	start := time.Now()
+	recs := []mq.Record{
+		{Time: start, Broker: "emqtt", Direction: mq.DIRECTION_PUBLISH, Topic: "a", Payload: []byte("1"), Qos: 1},
+		{Time: start.Add(40 * time.Millisecond), Broker: "emqtt", Direction: mq.DIRECTION_RECEIVE, Topic: "b", Payload: []byte("2"), Retain: true},
+		{Time: start.Add(80 * time.Millisecond), Broker: "rabbitmq", Direction: mq.DIRECTION_PUBLISH, Topic: "c", Payload: []byte("3")},
+	}
+	for _, rec := range recs {
+		if err := r.Record(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []*mq.Message
+	p := &mq.Player{Speed: 2}
+	begin := time.Now()
+	err := p.Play(context.Background(), bytes.NewReader(buf.Bytes()), func(ctx context.Context, msg *mq.Message) error {
+		got = append(got, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed < 35*time.Millisecond {
+		t.Fatalf("两倍速回放耗时%v, 过快", elapsed)
+	}
+	if len(got) != len(recs) {
+		t.Fatalf("回放%d条消息, 期望%d条", len(got), len(recs))
+	}
+	for i, m := range got {
+		if m.Topic != recs[i].Topic || string(m.Payload) != string(recs[i].Payload) ||
+			m.Qos != recs[i].Qos || m.Retain != recs[i].Retain {
+			t.Fatalf("第%d条消息为%+v, 期望%+v", i, m, recs[i])
+		}
+	}
+
+	got = nil
+	p = &mq.Player{Speed: -1, Broker: "emqtt", Direction: mq.DIRECTION_RECEIVE}
+	if err := p.Play(context.Background(), bytes.NewReader(buf.Bytes()), func(ctx context.Context, msg *mq.Message) error {
+		got = append(got, msg)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Topic != "b" {
+		t.Fatalf("按方向过滤回放结果错误: %+v", got)
+	}
+}
+
+func TestRecordReader_Corrupt(t *testing.T) {
+	var buf bytes.Buffer
+	r := mq.NewRecorder(&buf)
+	if err := r.Record(mq.Record{Time: time.Now(), Broker: "emqtt", Topic: "a", Payload: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	// 第一条记录后追加一条 broker 长度过大的记录, 以及一条内容被截断的记录
+	for _, tail := range [][]byte{
+		binary.AppendUvarint([]byte{0, 1}, 1<<62),
+		binary.AppendUvarint([]byte{0, 1}, 10),
+	} {
+		rr, err := mq.NewRecordReader(bytes.NewReader(append(append([]byte{}, valid...), tail...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rr.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rr.Next(); err == nil || err == io.EOF {
+			t.Fatalf("读取损坏的记录应返回格式错误, 实际 %v", err)
+		}
+	}
+}
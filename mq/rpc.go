@@ -0,0 +1,166 @@
+package mq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// rpcEnvelope 为请求/响应的消息封装. MQTT 3.1 不支持消息属性,
+// 关联ID和响应topic随消息体以 JSON 发送, Payload 为按 Codec 编码的内容.
+type rpcEnvelope struct {
+	ID      string `json:"id"`
+	ReplyTo string `json:"replyTo,omitempty"`
+	Payload []byte `json:"payload,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RPCError is 服务端处理请求时返回的错误.
+type RPCError struct {
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return "RPC服务端错误." + e.Message
+}
+
+// newCorrelationID 生成随机的关联ID.
+func newCorrelationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成关联ID失败.%v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Request is 向 topic 发送请求并等待响应, 超时或取消由 ctx 控制.
+// val 按 Codec、Compression 编码, 返回服务端响应的原始内容, 可使用 DecodePayload 解码.
+// 响应topic为 reply/<关联ID>, 服务端使用 RPCServer 处理请求.
+func (p *Emqtt) Request(ctx context.Context, topic string, val interface{}) ([]byte, error) {
+	codec, err := GetCodec(p.Codec)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := EncodePayload(codec, p.Compression, val)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newCorrelationID()
+	if err != nil {
+		return nil, err
+	}
+	env, err := json.Marshal(rpcEnvelope{ID: id, ReplyTo: "reply/" + id, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+
+	c, release, err := p.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	sub, err := c.subscribe("reply/"+id, &subscribeOptions{qos: 1})
+	if err != nil {
+		return nil, fmt.Errorf("EMQTT订阅响应topic失败.%v", err)
+	}
+	defer c.unsubscribe("reply/" + id)
+
+	err = c.publish(topic, env, &publishOptions{qos: 1})
+	if err != nil {
+		return nil, fmt.Errorf("EMQTT发送请求失败.%v", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("EMQTT等待响应失败.%v", ctx.Err())
+		case <-c.done():
+			return nil, c.closeErr()
+		case in := <-sub.ch:
+			in.ack()
+			var reply rpcEnvelope
+			if err := json.Unmarshal(in.msg.Payload, &reply); err != nil || reply.ID != id {
+				continue
+			}
+			if reply.Error != "" {
+				return nil, &RPCError{Message: reply.Error}
+			}
+			return reply.Payload, nil
+		}
+	}
+}
+
+// RPCHandler is 命令处理函数, 返回值按服务端 Emqtt 的 Codec 编码后作为响应.
+type RPCHandler func(ctx context.Context, req *Message) (interface{}, error)
+
+// RPCServer is 基于 MQTT 的命令服务端, 命令 topic 为 <prefix>/<命令名>.
+type RPCServer struct {
+	client *Emqtt
+	prefix string
+
+	mu       sync.RWMutex
+	handlers map[string]RPCHandler
+}
+
+// NewRPCServer is 创建命令服务端, client 建议先调用 Connect.
+func NewRPCServer(client *Emqtt, prefix string) *RPCServer {
+	return &RPCServer{
+		client:   client,
+		prefix:   strings.TrimSuffix(prefix, "/"),
+		handlers: make(map[string]RPCHandler),
+	}
+}
+
+// Handle is 注册命令处理函数.
+func (s *RPCServer) Handle(command string, h RPCHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = h
+}
+
+// Serve is 订阅命令 topic 并处理请求, 阻塞直到 ctx 结束或连接断开.
+func (s *RPCServer) Serve(ctx context.Context) error {
+	return s.client.Subscribe(ctx, s.prefix+"/+", s.handle, WithSubscribeQos(1))
+}
+
+func (s *RPCServer) handle(ctx context.Context, msg *Message) error {
+	var req rpcEnvelope
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return fmt.Errorf("RPC请求格式错误.%v", err)
+	}
+	if req.ReplyTo == "" {
+		return errors.New("RPC请求缺少响应topic")
+	}
+	command := msg.Topic[strings.LastIndexByte(msg.Topic, '/')+1:]
+	s.mu.RLock()
+	h, ok := s.handlers[command]
+	s.mu.RUnlock()
+
+	reply := rpcEnvelope{ID: req.ID}
+	if !ok {
+		reply.Error = "未知命令" + command
+	} else if res, err := h(ctx, &Message{Topic: msg.Topic, Payload: req.Payload, Qos: msg.Qos}); err != nil {
+		reply.Error = err.Error()
+	} else if reply.Payload, err = s.encode(res); err != nil {
+		reply.Error = err.Error()
+	}
+	b, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	// 响应直接发送到 ReplyTo, 不经过路由规则
+	return s.client.publish(req.ReplyTo, b, noFields, &publishOptions{qos: 1})
+}
+
+func (s *RPCServer) encode(v interface{}) ([]byte, error) {
+	codec, err := GetCodec(s.client.Codec)
+	if err != nil {
+		return nil, err
+	}
+	return EncodePayload(codec, s.client.Compression, v)
+}
@@ -0,0 +1,103 @@
+package mq_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zhgqiang/commongo/mq"
+	"github.com/zhgqiang/commongo/mq/mqtest"
+)
+
+func TestEmqtt_Request(t *testing.T) {
+	b, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	server := b.Config()
+	server.ClientID = "server"
+	if err := server.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	rpc := mq.NewRPCServer(&server, "cmd/dev1")
+	rpc.Handle("echo", func(ctx context.Context, req *mq.Message) (interface{}, error) {
+		var v map[string]string
+		if err := mq.DecodePayload("", "", req.Payload, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	rpc.Handle("fail", func(ctx context.Context, req *mq.Message) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rpc.Serve(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	client := b.Config()
+	reqCtx, reqCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer reqCancel()
+	reply, err := client.Request(reqCtx, "cmd/dev1/echo", map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal("请求失败", err)
+	}
+	if string(reply) != `{"a":"b"}` {
+		t.Fatalf("响应不符合预期: %s", reply)
+	}
+
+	_, err = client.Request(reqCtx, "cmd/dev1/fail", nil)
+	if rpcErr, ok := err.(*mq.RPCError); !ok || rpcErr.Message != "boom" {
+		t.Fatalf("错误不符合预期: %v", err)
+	}
+}
+
+func TestRPCServer_Backlog(t *testing.T) {
+	const n = 150
+	b, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	server := b.Config()
+	server.ClientID = "server"
+	server.Timeout = 2
+	if err := server.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	release := make(chan struct{})
+	rpc := mq.NewRPCServer(&server, "cmd/dev1")
+	rpc.Handle("echo", func(ctx context.Context, req *mq.Message) (interface{}, error) {
+		<-release
+		return "ok", nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rpc.Serve(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// 第一个请求处理期间积压大量请求, 响应的 PUBACK 不能被积压的请求阻塞
+	client := b.Config()
+	client.ClientID = "client"
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := 0; i < n; i++ {
+		req := fmt.Sprintf(`{"id":"%d","replyTo":"reply/backlog"}`, i)
+		if err := client.Publish("cmd/dev1/echo", []byte(req), mq.WithQos(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+	if _, err := b.WaitMessages("reply/backlog", n, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,157 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/zhgqiang/commongo/data"
+)
+
+// SchemaError is 消息体不符合 JSON Schema 的错误.
+type SchemaError struct {
+	Topic  string
+	Errors []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("topic %s 的消息不符合JSON Schema.%s", e.Topic, strings.Join(e.Errors, "; "))
+}
+
+// topicSchema 为一个 topic 过滤器及其 JSON Schema.
+type topicSchema struct {
+	filter string
+	schema *gojsonschema.Schema
+}
+
+// SchemaRegistry is 按 topic 注册的 JSON Schema, 用于校验发送和收到的消息体.
+// 过滤器使用 MQTT 风格并支持通配符, RabbitMQ 的 routing key 按 TopicFromRoutingKey 转换后匹配,
+// 匹配多个过滤器时使用最先注册的, 没有匹配的 topic 不校验.
+type SchemaRegistry struct {
+	mu      sync.RWMutex
+	schemas []topicSchema
+}
+
+// NewSchemaRegistry is 创建 JSON Schema 注册表.
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{}
+}
+
+// Register is 为匹配 filter 的 topic 注册 JSON Schema, 重复注册同一过滤器时替换原 Schema.
+func (r *SchemaRegistry) Register(filter string, schema data.JSON) error {
+	s, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema))
+	if err != nil {
+		return fmt.Errorf("topic %s 的JSON Schema错误.%v", filter, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.schemas {
+		if r.schemas[i].filter == filter {
+			r.schemas[i].schema = s
+			return nil
+		}
+	}
+	r.schemas = append(r.schemas, topicSchema{filter: filter, schema: s})
+	return nil
+}
+
+// lookup 返回 topic 对应的 Schema.
+func (r *SchemaRegistry) lookup(topic string) *gojsonschema.Schema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	alt := TopicFromRoutingKey(topic)
+	for _, s := range r.schemas {
+		if MatchTopic(s.filter, topic) || MatchTopic(s.filter, alt) {
+			return s.schema
+		}
+	}
+	return nil
+}
+
+// Validate is 校验 topic 的消息体, 不符合 Schema 或不是合法 JSON 时返回 *SchemaError,
+// topic 没有注册 Schema 时返回 nil.
+func (r *SchemaRegistry) Validate(topic string, payload data.JSON) error {
+	s := r.lookup(topic)
+	if s == nil {
+		return nil
+	}
+	res, err := s.Validate(gojsonschema.NewBytesLoader(payload))
+	if err != nil {
+		return &SchemaError{Topic: topic, Errors: []string{err.Error()}}
+	}
+	if res.Valid() {
+		return nil
+	}
+	errs := make([]string, len(res.Errors()))
+	for i, e := range res.Errors() {
+		errs[i] = e.String()
+	}
+	return &SchemaError{Topic: topic, Errors: errs}
+}
+
+// Publisher is 返回发送前校验消息体的 Publisher, 校验失败时不发送并返回 *SchemaError.
+func (r *SchemaRegistry) Publisher(pub Publisher) Publisher {
+	return &schemaPublisher{registry: r, pub: pub}
+}
+
+// schemaPublisher 发送前校验消息体.
+type schemaPublisher struct {
+	registry *SchemaRegistry
+	pub      Publisher
+}
+
+func (p *schemaPublisher) Publish(topic string, payload []byte, opts ...PublishOption) error {
+	if err := p.registry.Validate(topic, payload); err != nil {
+		return err
+	}
+	return p.pub.Publish(topic, payload, opts...)
+}
+
+// QuarantinedMessage is 发送到隔离 topic 的消息体, 内容类型为 application/json.
+type QuarantinedMessage struct {
+	Topic   string            `json:"topic"`
+	Errors  []string          `json:"errors"`
+	Payload string            `json:"payload"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Quarantine is 隔离区, 校验失败的消息发送到 Topic/<原topic>.
+type Quarantine struct {
+	Publisher Publisher
+	Topic     string
+}
+
+// Validator is 返回校验收到的消息体的中间件.
+// 校验失败的消息不调用 h: q 不为空时包装为 QuarantinedMessage 发送到隔离区并返回 nil,
+// 发送失败时返回错误; q 为空时返回 Permanent(*SchemaError), RabbitMQ 消费时直接进入死信.
+func (r *SchemaRegistry) Validator(q *Quarantine) Middleware {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			err := r.Validate(msg.Topic, msg.Payload)
+			if err == nil {
+				return h(ctx, msg)
+			}
+			if q == nil {
+				return Permanent(err)
+			}
+			body, merr := json.Marshal(&QuarantinedMessage{
+				Topic:   msg.Topic,
+				Errors:  err.(*SchemaError).Errors,
+				Payload: string(msg.Payload),
+				Headers: msg.Headers,
+			})
+			if merr != nil {
+				return merr
+			}
+			topic := strings.TrimSuffix(q.Topic, "/") + "/" + TopicFromRoutingKey(msg.Topic)
+			if perr := q.Publisher.Publish(topic, body, WithContentType("application/json"), WithQos(1)); perr != nil {
+				return fmt.Errorf("隔离消息发送失败.%v", perr)
+			}
+			return nil
+		}
+	}
+}
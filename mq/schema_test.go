@@ -0,0 +1,77 @@
+package mq_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zhgqiang/commongo/mq"
+)
+
+const telemetrySchema = `{
+	"type": "object",
+	"properties": {"temp": {"type": "number"}},
+	"required": ["temp"]
+}`
+
+func TestSchemaRegistry(t *testing.T) {
+	r := mq.NewSchemaRegistry()
+	if err := r.Register("dev/+/telemetry", []byte(telemetrySchema)); err != nil {
+		t.Fatal(err)
+	}
+	b := mq.NewMemoryBroker()
+	defer b.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	quarantined := subscribe(t, ctx, b, "quarantine/#")
+	time.Sleep(20 * time.Millisecond)
+
+	pub := r.Publisher(b)
+	var se *mq.SchemaError
+	if err := pub.Publish("dev/1/telemetry", []byte(`{"temp":"hot"}`)); !errors.As(err, &se) {
+		t.Fatalf("发送不符合Schema的消息应返回SchemaError: %v", err)
+	}
+	if err := pub.Publish("dev/1/status", []byte(`not json`)); err != nil {
+		t.Fatalf("未注册Schema的topic不应校验: %v", err)
+	}
+
+	var handled []string
+	h := r.Validator(&mq.Quarantine{Publisher: b, Topic: "quarantine"})(func(ctx context.Context, msg *mq.Message) error {
+		handled = append(handled, string(msg.Payload))
+		return nil
+	})
+	msgs := []*mq.Message{
+		{Topic: "dev/1/telemetry", Payload: []byte(`{"temp":21.5}`)},
+		{Topic: "dev.2.telemetry", Payload: []byte(`{"humidity":40}`)},
+		{Topic: "dev/3/telemetry", Payload: []byte(`{temp`)},
+	}
+	for _, msg := range msgs {
+		if err := h(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(handled) != 1 || handled[0] != `{"temp":21.5}` {
+		t.Fatalf("只应处理合法的消息: %v", handled)
+	}
+	for _, want := range []string{"quarantine/dev/2/telemetry", "quarantine/dev/3/telemetry"} {
+		select {
+		case msg := <-quarantined:
+			var qm mq.QuarantinedMessage
+			if err := json.Unmarshal(msg.Payload, &qm); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Topic != want || len(qm.Errors) == 0 {
+				t.Fatalf("隔离消息错误: %s %+v", msg.Topic, qm)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("未收到隔离消息")
+		}
+	}
+
+	h = r.Validator(nil)(func(ctx context.Context, msg *mq.Message) error { return nil })
+	if err := h(ctx, msgs[1]); !errors.As(err, &se) {
+		t.Fatalf("未配置隔离区时应返回SchemaError: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package mq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TopicRoute is 路由规则, 当消息字段 Field 的值等于 Value 时使用 Topic 发送.
+// Value 为空时只要消息包含 Field 即匹配, Topic 可以是包含占位符的模板.
+type TopicRoute struct {
+	Field string `json:"field" toml:"field" description:"匹配的消息字段"`
+	Value string `json:"value" toml:"value" description:"匹配的字段值,为空时匹配任意值"`
+	Topic string `json:"topic" toml:"topic" description:"匹配后使用的topic模板"`
+}
+
+// FormatTopic is 使用 vars 填充 topic 模板中的 {name} 占位符.
+// vars 可以是 map 或结构体, 结构体按 json 标签取值, 如
+// FormatTopic("factory/{line}/{device}/telemetry", map[string]string{"line": "l1", "device": "d1"}).
+func FormatTopic(template string, vars interface{}) (string, error) {
+	if !strings.Contains(template, "{") {
+		return template, nil
+	}
+	fields, err := topicFields(vars)
+	if err != nil {
+		return "", err
+	}
+	return formatTopic(template, fields)
+}
+
+func formatTopic(template string, fields map[string]interface{}) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			b.WriteString(template)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("topic模板缺少'}'.%s", template)
+		}
+		end += start
+		name := template[start+1 : end]
+		val, ok := fields[name]
+		if !ok || val == nil {
+			return "", fmt.Errorf("topic模板变量%s不存在", name)
+		}
+		s := fieldString(val)
+		if s == "" || strings.ContainsAny(s, "/+#") {
+			return "", fmt.Errorf("topic模板变量%s的值非法.%q", name, s)
+		}
+		b.WriteString(template[:start])
+		b.WriteString(s)
+		template = template[end+1:]
+	}
+}
+
+// topicFields 将 map 或结构体转为字段表.
+func topicFields(v interface{}) (map[string]interface{}, error) {
+	switch vv := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return vv, nil
+	case map[string]string:
+		m := make(map[string]interface{}, len(vv))
+		for k, s := range vv {
+			m[k] = s
+		}
+		return m, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m, err := payloadFields(b)
+	if err != nil {
+		return nil, fmt.Errorf("topic模板变量必须是map或结构体.%v", err)
+	}
+	return m, nil
+}
+
+// payloadFields 将 JSON 对象解析为字段表, 数字保留为 json.Number, 避免大整数变为科学计数法.
+func payloadFields(payload []byte) (map[string]interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	var m map[string]interface{}
+	if err := d.Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// fieldString 将字段值转为字符串, 浮点数不使用科学计数法.
+func fieldString(v interface{}) string {
+	switch n := v.(type) {
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(n), 'f', -1, 32)
+	}
+	return fmt.Sprint(v)
+}
+
+// matchRoute 返回第一个匹配 fields 的路由规则.
+func matchRoute(routes []TopicRoute, fields map[string]interface{}) (TopicRoute, bool) {
+	for _, r := range routes {
+		val, ok := fields[r.Field]
+		if !ok || val == nil {
+			continue
+		}
+		if r.Value == "" || fieldString(val) == r.Value {
+			return r, true
+		}
+	}
+	return TopicRoute{}, false
+}
+
+// noFields 用于不按消息字段选择 topic 的发送.
+func noFields() (map[string]interface{}, error) {
+	return nil, nil
+}
+
+// resolveTopic 根据路由规则和模板变量计算最终的 topic.
+// fields 为消息字段, 仅在需要时调用.
+func resolveTopic(routes []TopicRoute, topic string, vars interface{}, fields func() (map[string]interface{}, error)) (string, error) {
+	var msgFields map[string]interface{}
+	var err error
+	if len(routes) > 0 {
+		if msgFields, err = fields(); err != nil {
+			return "", err
+		}
+		if r, ok := matchRoute(routes, msgFields); ok {
+			topic = r.Topic
+		}
+	}
+	if !strings.Contains(topic, "{") {
+		return topic, nil
+	}
+	if vars != nil {
+		return FormatTopic(topic, vars)
+	}
+	if msgFields == nil {
+		if msgFields, err = fields(); err != nil {
+			return "", err
+		}
+	}
+	return formatTopic(topic, msgFields)
+}
+
+// MatchTopic returns true if topic 匹配订阅过滤器 filter, 支持 + 和 # 通配符.
+func MatchTopic(filter, topic string) bool {
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	// 以 $ 开头的系统 topic 不匹配以通配符开头的过滤器
+	if strings.HasPrefix(topic, "$") && (fs[0] == "+" || fs[0] == "#") {
+		return false
+	}
+	for i, f := range fs {
+		if f == "#" {
+			return i == len(fs)-1
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if f != "+" && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
+
+// shareTopic 去掉共享订阅前缀 $share/<group>/, 返回用于匹配消息的过滤器.
+func shareTopic(filter string) string {
+	if !strings.HasPrefix(filter, "$share/") {
+		return filter
+	}
+	parts := strings.SplitN(filter, "/", 3)
+	if len(parts) < 3 {
+		return filter
+	}
+	return parts[2]
+}
+
+// RoutingKey is 将 MQTT topic 或过滤器转换为 AMQP routing key 或绑定键,
+// / 转换为 ., 单层通配符 + 转换为 *, 多层通配符 # 不变.
+func RoutingKey(topic string) string {
+	levels := strings.Split(topic, "/")
+	for i, l := range levels {
+		if l == "+" {
+			levels[i] = "*"
+		}
+	}
+	return strings.Join(levels, ".")
+}
+
+// TopicFromRoutingKey is 将 AMQP routing key 转换为 MQTT topic, RoutingKey 的逆操作.
+func TopicFromRoutingKey(key string) string {
+	return strings.ReplaceAll(key, ".", "/")
+}
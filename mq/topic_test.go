@@ -0,0 +1,120 @@
+package mq_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zhgqiang/commongo/mq"
+	"github.com/zhgqiang/commongo/mq/mqtest"
+)
+
+func TestFormatTopic(t *testing.T) {
+	type device struct {
+		Line   string `json:"line"`
+		Device string `json:"device"`
+	}
+	cases := []struct {
+		vars interface{}
+		want string
+		ok   bool
+	}{
+		{map[string]string{"line": "l1", "device": "d1"}, "factory/l1/d1/telemetry", true},
+		{map[string]interface{}{"line": 2, "device": "d2"}, "factory/2/d2/telemetry", true},
+		{device{Line: "l3", Device: "d3"}, "factory/l3/d3/telemetry", true},
+		{map[string]string{"line": "l1"}, "", false},
+		{map[string]string{"line": "l1", "device": "a/b"}, "", false},
+		{map[string]string{"line": "l1", "device": "#"}, "", false},
+	}
+	for _, c := range cases {
+		got, err := mq.FormatTopic("factory/{line}/{device}/telemetry", c.vars)
+		if c.ok != (err == nil) {
+			t.Fatalf("FormatTopic(%v) 错误不符合预期: %v", c.vars, err)
+		}
+		if got != c.want {
+			t.Fatalf("FormatTopic(%v) = %q, 期望 %q", c.vars, got, c.want)
+		}
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"a/b/c", "a/b/c", true},
+		{"a/+/c", "a/b/c", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"#", "a/b", true},
+		{"+/+", "a/b", true},
+		{"a/b", "a/b/c", false},
+		{"#", "$SYS/uptime", false},
+	}
+	for _, c := range cases {
+		if got := mq.MatchTopic(c.filter, c.topic); got != c.want {
+			t.Fatalf("MatchTopic(%q, %q) = %v, 期望 %v", c.filter, c.topic, got, c.want)
+		}
+	}
+}
+
+func TestRoutingKey(t *testing.T) {
+	cases := []struct {
+		topic, key string
+	}{
+		{"a/b/c", "a.b.c"},
+		{"a/+/c", "a.*.c"},
+		{"a/#", "a.#"},
+		{"orders", "orders"},
+	}
+	for _, c := range cases {
+		if got := mq.RoutingKey(c.topic); got != c.key {
+			t.Fatalf("RoutingKey(%q) = %q, 期望 %q", c.topic, got, c.key)
+		}
+	}
+	if got := mq.TopicFromRoutingKey("a.b.c"); got != "a/b/c" {
+		t.Fatalf("TopicFromRoutingKey = %q, 期望 a/b/c", got)
+	}
+}
+
+func TestSubject(t *testing.T) {
+	cases := []struct {
+		topic, subject string
+	}{
+		{"a/b/c", "a.b.c"},
+		{"a/+/c", "a.*.c"},
+		{"a/#", "a.>"},
+		{"orders", "orders"},
+	}
+	for _, c := range cases {
+		if got := mq.Subject(c.topic); got != c.subject {
+			t.Fatalf("Subject(%q) = %q, 期望 %q", c.topic, got, c.subject)
+		}
+	}
+}
+
+func TestEmqtt_PublishRoutes(t *testing.T) {
+	b, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	p := b.Config()
+	p.Routes = []mq.TopicRoute{{Field: "type", Value: "2", Topic: "alarm/{id}"}}
+	if err := p.Publish("telemetry/{id}", []byte(`{"id":1234567,"type":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish("telemetry/{id}", []byte(`{"id":12345678901234567,"type":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.WaitMessages("alarm/1234567", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.WaitMessages("telemetry/12345678901234567", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish("telemetry/{id}", []byte("not json")); err == nil {
+		t.Fatal("消息体不是JSON时应返回错误")
+	}
+}
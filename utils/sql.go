@@ -191,6 +191,22 @@ func ExecuteSQLQuery(db *sql.DB, query string) error {
 // where the keys are column names and the
 // the values are SQL values to be inserted into those columns.
 func SQLInsertData(db *sql.DB, d data.JSON, tableName string, onDupKeyUpdate bool, onDupKeyFields []string, batchSize int) error {
+	return insertData(db, d, tableName, onDupKeyUpdate, onDupKeyFields, batchSize)
+}
+
+// SQLInsertDataTx is the same as SQLInsertData but executes the INSERT
+// statements in tx, so the rows are committed or rolled back together
+// with the other statements of the transaction.
+func SQLInsertDataTx(tx *sql.Tx, d data.JSON, tableName string, onDupKeyUpdate bool, onDupKeyFields []string, batchSize int) error {
+	return insertData(tx, d, tableName, onDupKeyUpdate, onDupKeyFields, batchSize)
+}
+
+// preparer is implemented by both *sql.DB and *sql.Tx.
+type preparer interface {
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+func insertData(db preparer, d data.JSON, tableName string, onDupKeyUpdate bool, onDupKeyFields []string, batchSize int) error {
 	objects, err := data.ObjectsFromJSON(d)
 	if err != nil {
 		return err
@@ -213,7 +229,7 @@ func SQLInsertData(db *sql.DB, d data.JSON, tableName string, onDupKeyUpdate boo
 	return insertObjects(db, objects, tableName, onDupKeyUpdate, onDupKeyFields)
 }
 
-func insertObjects(db *sql.DB, objects []map[string]interface{}, tableName string, onDupKeyUpdate bool, onDupKeyFields []string) error {
+func insertObjects(db preparer, objects []map[string]interface{}, tableName string, onDupKeyUpdate bool, onDupKeyFields []string) error {
 	// logger.Info("SQLInsertData: building INSERT for len(objects) =", len(objects))
 	insertSQL, vals := buildInsertSQL(objects, tableName, onDupKeyUpdate, onDupKeyFields)
 